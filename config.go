@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	sigsjson "sigs.k8s.io/json"
+)
+
+// The solver config is versioned by its apiVersion field. Configs without an
+// apiVersion use the original, unversioned form, which only has apiKey, and
+// are converted to the current version when they are loaded. The schema of
+// each version is published in the schema directory.
+const (
+	configAPIVersionV1 = "v1"
+
+	defaultTimeout = time.Minute
+	minTimeout     = time.Second
+	maxTimeout     = 5 * time.Minute
+	minTTL         = 60
+	maxTTL         = 86400
+)
+
+// unversionedConfig is the solver config as it was before it was versioned
+type unversionedConfig struct {
+	APIKey secretKeySelector `json:"apiKey"`
+}
+
+// loadConfig is a small helper function that decodes JSON configuration into
+// the typed config struct. Unknown fields are rejected and the decoded config
+// is validated, so mistakes are reported instead of silently ignored.
+func loadConfig(cfgJSON *extapi.JSON) (customDNSProviderConfig, error) {
+	cfg := customDNSProviderConfig{}
+	// handle the 'base case' where no configuration has been provided
+	if cfgJSON == nil {
+		cfgJSON = &extapi.JSON{Raw: []byte("{}")}
+	}
+
+	var version struct {
+		APIVersion string `json:"apiVersion"`
+	}
+
+	err := json.Unmarshal(cfgJSON.Raw, &version)
+	if err != nil {
+		return cfg, fmt.Errorf("%w: error decoding solver config: %w", ErrInvalidConfig, err)
+	}
+
+	switch version.APIVersion {
+	case "":
+		var unversioned unversionedConfig
+
+		err = decodeStrict(cfgJSON.Raw, &unversioned)
+		if err != nil {
+			return cfg, err
+		}
+
+		cfg = convertUnversionedConfig(unversioned)
+	case configAPIVersionV1:
+		err = decodeStrict(cfgJSON.Raw, &cfg)
+		if err != nil {
+			return cfg, err
+		}
+	default:
+		return cfg, fmt.Errorf("%w: %w", ErrInvalidConfig,
+			field.NotSupported(field.NewPath("apiVersion"), version.APIVersion, []string{configAPIVersionV1}))
+	}
+
+	if cfg.Timeout.Duration == 0 {
+		cfg.Timeout.Duration = defaultTimeout
+	}
+
+	errs := validateConfig(cfg)
+	if len(errs) > 0 {
+		return cfg, fmt.Errorf("%w: %w", ErrInvalidConfig, errs.ToAggregate())
+	}
+
+	return cfg, nil
+}
+
+// decodeStrict decodes JSON into out, matching field names case-sensitively
+// and rejecting unknown and duplicate fields
+func decodeStrict(raw []byte, out any) error {
+	strictErrs, err := sigsjson.UnmarshalStrict(raw, out)
+	if err != nil {
+		return fmt.Errorf("%w: error decoding solver config: %w", ErrInvalidConfig, err)
+	}
+
+	if len(strictErrs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, errors.Join(strictErrs...))
+	}
+
+	return nil
+}
+
+// convertUnversionedConfig converts an unversioned config to the current
+// config version
+func convertUnversionedConfig(unversioned unversionedConfig) customDNSProviderConfig {
+	return customDNSProviderConfig{
+		APIVersion: configAPIVersionV1,
+		APIKey:     unversioned.APIKey,
+	}
+}
+
+// validateConfig checks the semantics of a decoded config
+func validateConfig(cfg customDNSProviderConfig) field.ErrorList {
+	var errs field.ErrorList
+
+	if cfg.APIKey != (secretKeySelector{}) || (len(cfg.Credentials) == 0 && cfg.ZoneAPIKeys == nil) {
+		errs = append(errs, validateSecretKeySelector(cfg.APIKey, field.NewPath("apiKey"))...)
+	}
+
+	for i, credentials := range cfg.Credentials {
+		path := field.NewPath("credentials").Index(i)
+
+		if len(credentials.Zones) == 0 {
+			errs = append(errs, field.Required(path.Child("zones"), "at least one zone must be set"))
+		}
+
+		for j, zone := range credentials.Zones {
+			for _, msg := range validation.IsDNS1123Subdomain(normalizeZone(zone)) {
+				errs = append(errs, field.Invalid(path.Child("zones").Index(j), zone, msg))
+			}
+		}
+
+		errs = append(errs, validateSecretKeySelector(credentials.APIKey, path.Child("apiKey"))...)
+	}
+
+	if cfg.ZoneAPIKeys != nil {
+		path := field.NewPath("zoneAPIKeys")
+
+		if cfg.ZoneAPIKeys.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), "secret name must be set"))
+		}
+
+		errs = append(errs, validateNamespace(cfg.ZoneAPIKeys.Namespace, path.Child("namespace"))...)
+	}
+
+	if cfg.TTL != 0 && (cfg.TTL < minTTL || cfg.TTL > maxTTL) {
+		errs = append(errs, field.Invalid(field.NewPath("ttl"), cfg.TTL,
+			fmt.Sprintf("must be between %d and %d seconds", minTTL, maxTTL)))
+	}
+
+	if cfg.Timeout.Duration < minTimeout || cfg.Timeout.Duration > maxTimeout {
+		errs = append(errs, field.Invalid(field.NewPath("timeout"), cfg.Timeout.Duration.String(),
+			fmt.Sprintf("must be between %s and %s", minTimeout, maxTimeout)))
+	}
+
+	return errs
+}
+
+// validateSecretKeySelector checks that a secret key selector names a secret
+// and a key
+func validateSecretKeySelector(selector secretKeySelector, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if selector.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), "secret name must be set"))
+	}
+
+	if selector.Key == "" {
+		errs = append(errs, field.Required(path.Child("key"), "secret key must be set"))
+	}
+
+	if selector.SecondaryKey != "" && selector.SecondaryKey == selector.Key {
+		errs = append(errs, field.Invalid(path.Child("secondaryKey"), selector.SecondaryKey,
+			"must differ from key"))
+	}
+
+	errs = append(errs, validateNamespace(selector.Namespace, path.Child("namespace"))...)
+
+	return errs
+}
+
+// validateNamespace checks that an optional namespace is a valid name
+func validateNamespace(namespace string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			errs = append(errs, field.Invalid(path, namespace, msg))
+		}
+	}
+
+	return errs
+}
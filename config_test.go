@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		config      string
+		wantTimeout time.Duration
+		wantErr     string
+	}{
+		{
+			name:        "unversioned",
+			config:      `{"apiKey": {"name": "netactuate-api-key", "key": "netactuate-api-key"}}`,
+			wantTimeout: defaultTimeout,
+		},
+		{
+			name: "v1",
+			config: `{"apiVersion": "v1", "apiKey": {"name": "netactuate-api-key", "key": "netactuate-api-key"},
+				"ttl": 300, "timeout": "30s"}`,
+			wantTimeout: 30 * time.Second,
+		},
+		{
+			name: "credentials without default",
+			config: `{"apiVersion": "v1", "credentials": [{"zones": ["example.com"],
+				"apiKey": {"name": "netactuate-api-key", "key": "netactuate-api-key"}}]}`,
+			wantTimeout: defaultTimeout,
+		},
+		{
+			name:    "credentials without zones",
+			config:  `{"apiVersion": "v1", "credentials": [{"zones": [], "apiKey": {"name": "a", "key": "b"}}]}`,
+			wantErr: "credentials[0].zones: Required value",
+		},
+		{
+			name:    "zone api keys without name",
+			config:  `{"apiVersion": "v1", "zoneAPIKeys": {"namespace": "dns"}}`,
+			wantErr: "zoneAPIKeys.name: Required value",
+		},
+		{
+			name:    "unknown field",
+			config:  `{"apikey": {"name": "netactuate-api-key", "key": "netactuate-api-key"}}`,
+			wantErr: `unknown field "apikey"`,
+		},
+		{
+			name:    "unknown selector field",
+			config:  `{"apiKey": {"name": "netactuate-api-key", "value": "netactuate-api-key"}}`,
+			wantErr: `unknown field "apiKey.value"`,
+		},
+		{
+			name:    "v1 field in unversioned config",
+			config:  `{"apiKey": {"name": "netactuate-api-key", "key": "netactuate-api-key"}, "ttl": 300}`,
+			wantErr: `unknown field "ttl"`,
+		},
+		{
+			name:    "unsupported version",
+			config:  `{"apiVersion": "v2"}`,
+			wantErr: `apiVersion: Unsupported value: "v2"`,
+		},
+		{
+			name:    "missing key",
+			config:  `{"apiVersion": "v1", "apiKey": {"name": "netactuate-api-key"}}`,
+			wantErr: "apiKey.key: Required value",
+		},
+		{
+			name:    "no config",
+			wantErr: "apiKey.name: Required value",
+		},
+		{
+			name:    "bad ttl",
+			config:  `{"apiVersion": "v1", "apiKey": {"name": "a", "key": "b"}, "ttl": 5}`,
+			wantErr: "ttl: Invalid value: 5",
+		},
+		{
+			name:    "bad timeout",
+			config:  `{"apiVersion": "v1", "apiKey": {"name": "a", "key": "b"}, "timeout": "1h"}`,
+			wantErr: `timeout: Invalid value: "1h0m0s"`,
+		},
+		{
+			name:    "bad namespace",
+			config:  `{"apiVersion": "v1", "apiKey": {"name": "a", "key": "b", "namespace": "Not_Valid"}}`,
+			wantErr: `apiKey.namespace: Invalid value: "Not_Valid"`,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var cfgJSON *extapi.JSON
+			if testCase.config != "" {
+				cfgJSON = &extapi.JSON{Raw: []byte(testCase.config)}
+			}
+
+			got, err := loadConfig(cfgJSON)
+			if testCase.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Errorf("loadConfig() error = %v, want %q", err, testCase.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+
+			if got.APIVersion != configAPIVersionV1 || got.Timeout.Duration != testCase.wantTimeout {
+				t.Errorf("loadConfig() = %+v", got)
+			}
+		})
+	}
+}
+
+// TestConfigSchema checks that the published schema describes the same
+// fields as customDNSProviderConfig
+func TestConfigSchema(t *testing.T) {
+	t.Parallel()
+
+	raw, err := os.ReadFile("schema/config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"$defs"`
+	}
+
+	err = json.Unmarshal(raw, &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+
+	configType := reflect.TypeFor[customDNSProviderConfig]()
+	for i := range configType.NumField() {
+		name, _, _ := strings.Cut(configType.Field(i).Tag.Get("json"), ",")
+		want = append(want, name)
+	}
+
+	var got []string
+	for name := range schema.Defs["v1"].Properties {
+		got = append(got, name)
+	}
+
+	slices.Sort(want)
+	slices.Sort(got)
+
+	if !slices.Equal(got, want) {
+		t.Errorf("schema v1 properties = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiKeys holds the API keys for a zone. secondary is empty unless a
+// secondary key is configured and present in the secret.
+type apiKeys struct {
+	primary   string
+	secondary string
+}
+
+// loadAPIKey loads the netactuate API keys for the challenge's zone
+func (c *customDNSProviderSolver) loadAPIKey(
+	ctx context.Context, cfg customDNSProviderConfig, challengeRequest *v1alpha1.ChallengeRequest,
+) (apiKeys, error) {
+	ctx, span := c.tracer().Start(ctx, "loadAPIKey")
+
+	keys, err := c.loadSelectedAPIKey(ctx, cfg, challengeRequest)
+	if err != nil {
+		c.metrics.secretLookupFailed(err)
+		err = fmt.Errorf("%w: %w", ErrSecretLookup, err)
+	}
+
+	endSpan(span, err)
+
+	return keys, err
+}
+
+// loadSelectedAPIKey selects and reads the API keys for the challenge's zone
+func (c *customDNSProviderSolver) loadSelectedAPIKey(
+	ctx context.Context, cfg customDNSProviderConfig, challengeRequest *v1alpha1.ChallengeRequest,
+) (apiKeys, error) {
+	selector, err := c.selectAPIKey(ctx, cfg, challengeRequest)
+	if err != nil {
+		return apiKeys{}, err
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("secret.namespace", selector.Namespace),
+		attribute.String("secret.name", selector.Name),
+	)
+
+	return c.readSecretKey(ctx, selector, challengeRequest)
+}
+
+// withAPIKey calls apiCall with the primary API key and, if NetActuate
+// rejects it, again with the secondary key. The key that was used is logged
+// so operators can tell when a rotated out key is no longer needed.
+func (c *customDNSProviderSolver) withAPIKey(
+	ctx context.Context, keys apiKeys, apiCall func(apiKey string) error,
+) error {
+	err := apiCall(keys.primary)
+	if err == nil {
+		c.metrics.apiKeyUsed("primary")
+		logger(ctx).DebugContext(ctx, "NetActuate API call succeeded", "apiKey", "primary")
+
+		return nil
+	}
+
+	var ipErr *netactuate.IPNotAllowedError
+	if errors.As(err, &ipErr) {
+		logger(ctx).ErrorContext(ctx, ipNotAllowedGuidance(ipErr), "sourceIP", ipErr.SourceIP, "err", err)
+
+		return err
+	}
+
+	if !errors.Is(err, netactuate.ErrUnauthorized) || keys.secondary == "" {
+		return err
+	}
+
+	logger(ctx).WarnContext(ctx, "Primary API key rejected, retrying with secondary key", "err", err)
+
+	err = apiCall(keys.secondary)
+	if err != nil {
+		return err
+	}
+
+	c.metrics.apiKeyUsed("secondary")
+	logger(ctx).InfoContext(ctx, "NetActuate API call succeeded", "apiKey", "secondary")
+
+	return nil
+}
+
+// ipNotAllowedGuidance explains what to do when NetActuate rejects the
+// webhook's address
+func ipNotAllowedGuidance(err *netactuate.IPNotAllowedError) string {
+	address := "the webhook's egress address"
+	if err.SourceIP != "" {
+		address = err.SourceIP
+	}
+
+	return fmt.Sprintf("NetActuate rejected the request because it came from an address that is not in "+
+		"the account's API ACL, add %s to the ACL in the NetActuate portal or route the webhook's "+
+		"traffic through an allowed address", address)
+}
+
+// selectAPIKey picks the secret key holding the API key for the challenge's
+// ResolvedZone. The Credentials and ZoneAPIKeys entry for the longest zone
+// that is the ResolvedZone or one of its parents is used, with Credentials
+// winning ties. APIKey is used if no entry matches.
+func (c *customDNSProviderSolver) selectAPIKey(
+	ctx context.Context, cfg customDNSProviderConfig, challengeRequest *v1alpha1.ChallengeRequest,
+) (secretKeySelector, error) {
+	zone := normalizeZone(challengeRequest.ResolvedZone)
+
+	var selected secretKeySelector
+
+	longest := -1
+
+	for _, credentials := range cfg.Credentials {
+		for _, suffix := range credentials.Zones {
+			suffix = normalizeZone(suffix)
+			if len(suffix) > longest && zoneHasSuffix(zone, suffix) {
+				selected = credentials.APIKey
+				longest = len(suffix)
+			}
+		}
+	}
+
+	if cfg.ZoneAPIKeys != nil {
+		namespace, err := c.secretNamespace(cfg.ZoneAPIKeys.Namespace, challengeRequest)
+		if err != nil {
+			return selected, err
+		}
+
+		secret, err := c.secrets.get(ctx, namespace, cfg.ZoneAPIKeys.Name)
+		if err != nil {
+			return selected, fmt.Errorf("error getting zone api keys: %w", err)
+		}
+
+		for key := range secret.Data {
+			suffix := normalizeZone(key)
+			if len(suffix) > longest && zoneHasSuffix(zone, suffix) {
+				selected = secretKeySelector{Namespace: cfg.ZoneAPIKeys.Namespace}
+				selected.Name = cfg.ZoneAPIKeys.Name
+				selected.Key = key
+				longest = len(suffix)
+			}
+		}
+	}
+
+	if longest >= 0 {
+		return selected, nil
+	}
+
+	if cfg.APIKey.Name == "" {
+		return selected, fmt.Errorf("zone %s, %w", zone, ErrNoCredentials)
+	}
+
+	return cfg.APIKey, nil
+}
+
+// readSecretKey reads the value of a secret key
+func (c *customDNSProviderSolver) readSecretKey(
+	ctx context.Context, selector secretKeySelector, challengeRequest *v1alpha1.ChallengeRequest,
+) (apiKeys, error) {
+	namespace, err := c.secretNamespace(selector.Namespace, challengeRequest)
+	if err != nil {
+		return apiKeys{}, err
+	}
+
+	secret, err := c.secrets.get(ctx, namespace, selector.Name)
+	if err != nil {
+		return apiKeys{}, fmt.Errorf("error getting api key: %w", err)
+	}
+
+	keyBytes, ok := secret.Data[selector.Key]
+	if !ok {
+		return apiKeys{}, fmt.Errorf("secret key not found, namespace: %s name: %s, key: %s, %w",
+			namespace, selector.Name, selector.Key, ErrAPIKeyDecode)
+	}
+
+	keys := apiKeys{primary: string(keyBytes)}
+
+	// the secondary key is optional, it is usually removed once rotation is
+	// complete
+	if selector.SecondaryKey != "" {
+		keys.secondary = string(secret.Data[selector.SecondaryKey])
+	}
+
+	return keys, nil
+}
+
+// secretNamespace returns the namespace to read a secret from. Secrets are
+// read from the challenge's ResourceNamespace unless the config names
+// another namespace, which is only permitted for ClusterIssuers (whose
+// ResourceNamespace is the cluster resource namespace) and only for
+// namespaces listed in the webhook settings. This keeps namespaced Issuers
+// from reading secrets that belong to other namespaces. Challenges do not
+// carry their issuer's kind, so an Issuer in the cluster resource namespace is
+// treated as a ClusterIssuer.
+func (c *customDNSProviderSolver) secretNamespace(
+	namespace string, challengeRequest *v1alpha1.ChallengeRequest,
+) (string, error) {
+	if namespace == "" || namespace == challengeRequest.ResourceNamespace {
+		return challengeRequest.ResourceNamespace, nil
+	}
+
+	if challengeRequest.ResourceNamespace != c.settings.clusterResourceNamespace {
+		return "", fmt.Errorf("secret namespace %s requested from namespace %s, %w",
+			namespace, challengeRequest.ResourceNamespace, ErrSecretNamespaceNotAllowed)
+	}
+
+	if !slices.Contains(c.settings.secretNamespaces, namespace) {
+		return "", fmt.Errorf("secret namespace %s is not in the allowed secret namespaces, %w",
+			namespace, ErrSecretNamespaceNotAllowed)
+	}
+
+	return namespace, nil
+}
+
+// normalizeZone lower cases a zone name and removes any trailing dot
+func normalizeZone(zone string) string {
+	return strings.ToLower(strings.TrimSuffix(zone, "."))
+}
+
+// zoneHasSuffix reports whether zone is suffix or a subdomain of it
+func zoneHasSuffix(zone string, suffix string) bool {
+	return zone == suffix || strings.HasSuffix(zone, "."+suffix)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSelectAPIKey(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "zone-keys", Namespace: "cert-manager"},
+		Data: map[string][]byte{
+			"example.org":          []byte("example-org-key"),
+			"internal.example.com": []byte("internal-key"),
+		},
+	})
+
+	solver := &customDNSProviderSolver{
+		client:   client,
+		secrets:  &secretCache{client: client},
+		settings: webhookSettings{clusterResourceNamespace: "cert-manager"},
+	}
+
+	selector := func(name string, key string) secretKeySelector {
+		var s secretKeySelector
+
+		s.Name = name
+		s.Key = key
+
+		return s
+	}
+
+	cfg := customDNSProviderConfig{
+		APIKey: selector("default", "key"),
+		Credentials: []zoneCredentials{
+			{Zones: []string{"example.com."}, APIKey: selector("account-a", "key")},
+			{Zones: []string{"team.example.com", "example.net"}, APIKey: selector("account-b", "key")},
+		},
+		ZoneAPIKeys: &secretReference{Name: "zone-keys"},
+	}
+
+	tests := []struct {
+		name    string
+		zone    string
+		want    secretKeySelector
+		wantErr bool
+	}{
+		{name: "exact", zone: "example.com.", want: selector("account-a", "key")},
+		{name: "subdomain", zone: "www.example.com.", want: selector("account-a", "key")},
+		{name: "longest credentials", zone: "a.team.example.com.", want: selector("account-b", "key")},
+		{name: "longest zone map", zone: "internal.example.com.", want: selector("zone-keys", "internal.example.com")},
+		{name: "zone map", zone: "EXAMPLE.org.", want: selector("zone-keys", "example.org")},
+		{name: "suffix is not a parent", zone: "notexample.net.", want: selector("default", "key")},
+		{name: "default", zone: "example.info.", want: selector("default", "key")},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			challengeRequest := &v1alpha1.ChallengeRequest{
+				ResourceNamespace: "cert-manager",
+				ResolvedZone:      testCase.zone,
+			}
+
+			got, err := solver.selectAPIKey(t.Context(), cfg, challengeRequest)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("selectAPIKey() error = %v, wantErr %v", err, testCase.wantErr)
+
+				return
+			}
+
+			if got != testCase.want {
+				t.Errorf("selectAPIKey() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+
+	noDefault := cfg
+	noDefault.APIKey = secretKeySelector{}
+
+	_, err := solver.selectAPIKey(t.Context(), noDefault, &v1alpha1.ChallengeRequest{
+		ResourceNamespace: "cert-manager",
+		ResolvedZone:      "example.info.",
+	})
+	if err == nil {
+		t.Errorf("selectAPIKey() without a matching entry or default returned no error")
+	}
+}
+
+func TestWithAPIKey(t *testing.T) {
+	t.Parallel()
+
+	rejectKey := func(rejected string) func(apiKey string) error {
+		return func(apiKey string) error {
+			if apiKey == rejected {
+				return fmt.Errorf("test: %w", netactuate.ErrUnauthorized)
+			}
+
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name    string
+		keys    apiKeys
+		apiCall func(apiKey string) error
+		wantErr bool
+	}{
+		{
+			name:    "primary",
+			keys:    apiKeys{primary: "new", secondary: "old"},
+			apiCall: rejectKey("old"),
+		},
+		{
+			name:    "secondary",
+			keys:    apiKeys{primary: "new", secondary: "old"},
+			apiCall: rejectKey("new"),
+		},
+		{
+			name:    "no secondary",
+			keys:    apiKeys{primary: "new"},
+			apiCall: rejectKey("new"),
+			wantErr: true,
+		},
+		{
+			name: "other error",
+			keys: apiKeys{primary: "new", secondary: "old"},
+			apiCall: func(apiKey string) error {
+				if apiKey == "new" {
+					return netactuate.ErrHTTPNotOK
+				}
+
+				t.Error("secondary key used after a non authentication error")
+
+				return nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "ip not allowed",
+			keys: apiKeys{primary: "new", secondary: "old"},
+			apiCall: func(apiKey string) error {
+				if apiKey == "new" {
+					return &netactuate.IPNotAllowedError{Status: "403", Message: "IP not allowed", SourceIP: "192.0.2.10"}
+				}
+
+				t.Error("secondary key used after an ACL rejection")
+
+				return nil
+			},
+			wantErr: true,
+		},
+	}
+
+	solver := &customDNSProviderSolver{}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := solver.withAPIKey(t.Context(), testCase.keys, testCase.apiCall)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("withAPIKey() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+)
+
+const (
+	refusedType    = "type"
+	refusedChanged = "changed"
+	refusedName    = "name"
+)
+
+// defaultDeletableNames match the names of the records the webhook creates,
+// challenge records and their owner markers
+var defaultDeletableNames = []*regexp.Regexp{
+	regexp.MustCompile(`^_acme-challenge(\.|$)`),
+	regexp.MustCompile(`^_acme-owner\._acme-challenge(\.|$)`),
+}
+
+// checkDeletion applies the deletion policy to a record about to be deleted.
+// NetActuate deletes whatever record ID it is given, so the record is fetched
+// again and must still be the TXT record that was found, with a name matching
+// defaultDeletableNames or the DELETION_ALLOWED_NAMES setting. Refusals are
+// logged as errors and counted, as they point to a bug or a record changed
+// by someone else.
+func (c *customDNSProviderSolver) checkDeletion(
+	ctx context.Context, keys apiKeys, expected netactuate.DNSRecord,
+) error {
+	var err error
+
+	var current *netactuate.DNSRecord
+
+	err = c.withAPIKey(ctx, keys, func(apiKey string) error {
+		current, err = c.api.DNSRecordGet(ctx, apiKey, expected.ID)
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error fetching record %d before deleting it: %w", expected.ID, err)
+	}
+
+	reason := c.deletionRefusal(*current, expected)
+	if reason == "" {
+		return nil
+	}
+
+	c.metrics.deletionRefused(reason)
+	logger(ctx).ErrorContext(ctx, "Refusing to delete record",
+		"id", current.ID,
+		"type", current.RecordType,
+		"name", current.Name,
+		"expectedName", expected.Name,
+		"reason", reason,
+	)
+
+	return fmt.Errorf("record %d, %s %s, %s: %w",
+		current.ID, current.RecordType, current.Name, reason, ErrDeletionRefused,
+	)
+}
+
+// deletionRefusal returns why the deletion policy refuses to delete current,
+// or "" if it may be deleted
+func (c *customDNSProviderSolver) deletionRefusal(current netactuate.DNSRecord, expected netactuate.DNSRecord) string {
+	switch {
+	case current.RecordType != "TXT":
+		return refusedType
+	case current.ID != expected.ID || normalizeZone(current.Name) != normalizeZone(expected.Name):
+		return refusedChanged
+	}
+
+	name := normalizeZone(current.Name)
+
+	for _, pattern := range defaultDeletableNames {
+		if pattern.MatchString(name) {
+			return ""
+		}
+	}
+
+	for _, pattern := range c.settings.deletionAllowedNames {
+		if pattern.MatchString(name) {
+			return ""
+		}
+	}
+
+	return refusedName
+}
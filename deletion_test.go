@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+)
+
+func TestCheckDeletion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		record     netactuate.DNSRecord
+		expected   string
+		wantReason string
+	}{
+		{
+			name:     "challenge record",
+			record:   netactuate.DNSRecord{Name: "_acme-challenge.www." + fakeZone, RecordType: "TXT"},
+			expected: "_acme-challenge.www." + fakeZone,
+		},
+		{
+			name:     "owner marker",
+			record:   netactuate.DNSRecord{Name: "_acme-owner._acme-challenge." + fakeZone, RecordType: "TXT"},
+			expected: "_acme-owner._acme-challenge." + fakeZone,
+		},
+		{
+			name:     "allowlisted name",
+			record:   netactuate.DNSRecord{Name: "_dns-check." + fakeZone, RecordType: "TXT"},
+			expected: "_dns-check." + fakeZone,
+		},
+		{
+			name:       "allowlisted name inside another name",
+			record:     netactuate.DNSRecord{Name: "www._dns-check." + fakeZone, RecordType: "TXT"},
+			expected:   "www._dns-check." + fakeZone,
+			wantReason: refusedName,
+		},
+		{
+			name:       "not a TXT record",
+			record:     netactuate.DNSRecord{Name: "_acme-challenge." + fakeZone, RecordType: "A", Content: "192.0.2.1"},
+			expected:   "_acme-challenge." + fakeZone,
+			wantReason: refusedType,
+		},
+		{
+			name:       "name not allowed",
+			record:     netactuate.DNSRecord{Name: "www." + fakeZone, RecordType: "TXT"},
+			expected:   "www." + fakeZone,
+			wantReason: refusedName,
+		},
+		{
+			name:       "prefix inside a label",
+			record:     netactuate.DNSRecord{Name: "_acme-challengex." + fakeZone, RecordType: "TXT"},
+			expected:   "_acme-challengex." + fakeZone,
+			wantReason: refusedName,
+		},
+		{
+			name:       "record changed",
+			record:     netactuate.DNSRecord{Name: "_acme-challenge.other." + fakeZone, RecordType: "TXT"},
+			expected:   "_acme-challenge.www." + fakeZone,
+			wantReason: refusedChanged,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			api := newFakeNetActuate(t)
+			solver := newTestSolver(t, api)
+			allowed, err := compileFullMatch(`_dns-check\..+`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			solver.settings.deletionAllowedNames = []*regexp.Regexp{allowed}
+
+			metrics, err := newSolverMetrics(prometheus.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			solver.metrics = metrics
+
+			recordID := api.putRecord(testCase.record)
+			keys := apiKeys{primary: fakeAPIKey}
+			expected := netactuate.DNSRecord{Name: testCase.expected, RecordType: "TXT", ID: recordID}
+
+			err = solver.deleteTXTRecord(t.Context(), keys, expected)
+
+			if testCase.wantReason == "" {
+				if err != nil {
+					t.Errorf("deleteTXTRecord() error = %v", err)
+				}
+
+				if len(api.recordList()) != 0 {
+					t.Error("record was not deleted")
+				}
+
+				return
+			}
+
+			if !errors.Is(err, ErrDeletionRefused) {
+				t.Errorf("deleteTXTRecord() error = %v, want %v", err, ErrDeletionRefused)
+			}
+
+			if len(api.recordList()) != 1 {
+				t.Error("refused record was deleted")
+			}
+
+			if got := testutil.ToFloat64(metrics.deletionsRefused.WithLabelValues(testCase.wantReason)); got != 1 {
+				t.Errorf("deletions refused with reason %s = %v, want 1", testCase.wantReason, got)
+			}
+		})
+	}
+}
@@ -8,4 +8,18 @@ var (
 	ErrTXTRecordCreate   = errors.New("TXT record could not be created")
 	ErrTXTRecordFetch    = errors.New("TXT record fetch failed")
 	ErrTXTRecordDelete   = errors.New("TXT record delete failed")
+
+	ErrSecretNamespaceNotAllowed = errors.New("secret namespace not allowed")
+	ErrSecretCacheSync           = errors.New("secret cache did not sync")
+	ErrInvalidSetting            = errors.New("invalid webhook setting")
+	ErrInvalidConfig             = errors.New("invalid solver config")
+	ErrNoCredentials             = errors.New("no api key configured for zone")
+	ErrSecretLookup              = errors.New("error loading api key")
+	ErrChallengeNotFound         = errors.New("challenge not found")
+	ErrRecordNotOwned            = errors.New("record has no matching owner marker")
+	ErrDeletionRefused           = errors.New("record deletion refused by deletion policy")
+	ErrInvalidPolicy             = errors.New("invalid authorization policy")
+	ErrNotAuthorized             = errors.New("challenge not authorized by policy")
+	ErrRateLimited               = errors.New("challenge rate limited")
+	ErrLeaseLost                 = errors.New("lease lost to another replica")
 )
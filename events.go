@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmacmev1 "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+	cmapiv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmclientset "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
+	cmscheme "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned/scheme"
+	cminformers "github.com/cert-manager/cert-manager/pkg/client/informers/externalversions"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventsComponent         = "cert-manager-webhook-netactuate"
+	challengeLookupWait     = 10 * time.Second
+	challengeLookupInterval = time.Second
+	challengeKeyIndex       = "key"
+
+	reasonPresented        = "Presented"
+	reasonCleanedUp        = "CleanedUp"
+	reasonPresentFailed    = "PresentFailed"
+	reasonCleanUpFailed    = "CleanUpFailed"
+	reasonInvalidConfig    = "InvalidConfig"
+	reasonCredentialsError = "CredentialsError"
+	reasonRecordNotOwned   = "RecordNotOwned"
+	reasonDeletionRefused  = "DeletionRefused"
+	reasonNotAuthorized    = "NotAuthorized"
+	reasonRateLimited      = "RateLimited"
+	reasonIPNotAllowed     = "IPNotAllowed"
+)
+
+// apiKeyPattern matches the API key in a NetActuate request URL
+var apiKeyPattern = regexp.MustCompile(`([?&]key=)[^&\s"]*`)
+
+// challengeEvents records Events against the Challenge resources that
+// challenge requests belong to, so the outcome of Present and CleanUp shows
+// up in `kubectl describe challenge`. Challenges are found in an informer's
+// cache indexed by key and DNS name, the request's UID is left empty by
+// cert-manager. A nil *challengeEvents records nothing.
+type challengeEvents struct {
+	challenges cache.Indexer
+	synced     cache.InformerSynced
+	recorder   record.EventRecorder
+}
+
+// newChallengeEvents creates a challengeEvents whose Events are sent to the
+// API server until stopCh is closed. The Challenge cache syncs in the
+// background, Events are only recorded once it has.
+func newChallengeEvents(
+	client kubernetes.Interface, challenges cmclientset.Interface, stopCh <-chan struct{},
+) (*challengeEvents, error) {
+	index, synced, err := newChallengeIndex(challenges, stopCh)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+
+	go func() {
+		<-stopCh
+		broadcaster.Shutdown()
+	}()
+
+	return &challengeEvents{
+		challenges: index,
+		synced:     synced,
+		recorder:   broadcaster.NewRecorder(cmscheme.Scheme, corev1.EventSource{Component: eventsComponent}),
+	}, nil
+}
+
+// newChallengeIndex starts an informer for the Challenges of every
+// namespace, indexed by key and DNS name, and returns its cache and whether
+// it has synced. The informer runs until stopCh is closed.
+func newChallengeIndex(
+	challenges cmclientset.Interface, stopCh <-chan struct{},
+) (cache.Indexer, cache.InformerSynced, error) {
+	factory := cminformers.NewSharedInformerFactory(challenges, 0)
+	informer := factory.Acme().V1().Challenges().Informer()
+
+	err := informer.AddIndexers(cache.Indexers{challengeKeyIndex: challengeKey})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error adding challenge index: %w", err)
+	}
+
+	factory.Start(stopCh)
+
+	return informer.GetIndexer(), informer.HasSynced, nil
+}
+
+// challengeKey indexes Challenges by the key and DNS name cert-manager
+// copies into their challenge requests
+func challengeKey(obj any) ([]string, error) {
+	challenge, ok := obj.(*cmacmev1.Challenge)
+	if !ok {
+		return nil, nil
+	}
+
+	return []string{challenge.Spec.Key + " " + challenge.Spec.DNSName}, nil
+}
+
+// presented records the outcome of Present
+func (e *challengeEvents) presented(
+	ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest, recordID int, err error,
+) {
+	if err != nil {
+		e.failed(ctx, challengeRequest, reasonPresentFailed, err)
+
+		return
+	}
+
+	e.record(ctx, challengeRequest, corev1.EventTypeNormal, reasonPresented, fmt.Sprintf(
+		"Presented TXT record %s in zone %s, record ID %d",
+		challengeRequest.ResolvedFQDN, challengeRequest.ResolvedZone, recordID,
+	))
+}
+
+// cleanedUp records the outcome of CleanUp
+func (e *challengeEvents) cleanedUp(
+	ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest, recordID int, err error,
+) {
+	if err != nil {
+		e.failed(ctx, challengeRequest, reasonCleanUpFailed, err)
+
+		return
+	}
+
+	e.record(ctx, challengeRequest, corev1.EventTypeNormal, reasonCleanedUp, fmt.Sprintf(
+		"Deleted TXT record %s from zone %s, record ID %d",
+		challengeRequest.ResolvedFQDN, challengeRequest.ResolvedZone, recordID,
+	))
+}
+
+// failed records a Warning for err. Config, credential, ACL, ownership, policy
+// and rate limit errors have their own reasons, other errors use reason.
+func (e *challengeEvents) failed(
+	ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest, reason string, err error,
+) {
+	switch {
+	case errors.Is(err, ErrInvalidConfig):
+		reason = reasonInvalidConfig
+	case errors.Is(err, ErrSecretLookup):
+		reason = reasonCredentialsError
+	case errors.Is(err, ErrRecordNotOwned):
+		reason = reasonRecordNotOwned
+	case errors.Is(err, ErrDeletionRefused):
+		reason = reasonDeletionRefused
+	case errors.Is(err, ErrNotAuthorized):
+		reason = reasonNotAuthorized
+	case errors.Is(err, ErrRateLimited):
+		reason = reasonRateLimited
+	}
+
+	message := err.Error()
+
+	var ipErr *netactuate.IPNotAllowedError
+	if errors.As(err, &ipErr) {
+		reason = reasonIPNotAllowed
+		message = ipNotAllowedGuidance(ipErr) + ": " + message
+	}
+
+	e.record(ctx, challengeRequest, corev1.EventTypeWarning, reason, redactMessage(message))
+}
+
+// record records an Event against the request's Challenge. Failing to do so
+// is logged, and does not fail the challenge.
+func (e *challengeEvents) record(
+	ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest, eventType string, reason string, message string,
+) {
+	if e == nil {
+		return
+	}
+
+	challenge, err := e.findChallenge(challengeRequest)
+	if err == nil {
+		e.recorder.Event(challenge, eventType, reason, message)
+
+		return
+	}
+
+	// a Challenge created moments ago may not be in the cache yet, it is
+	// waited for off the request path, even if the challenge timed out
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), challengeLookupWait)
+		defer cancel()
+
+		var challenge *cmacmev1.Challenge
+
+		err := wait.PollUntilContextCancel(ctx, challengeLookupInterval, false, func(context.Context) (bool, error) {
+			var err error
+
+			challenge, err = e.findChallenge(challengeRequest)
+
+			return err == nil, nil
+		})
+		if err != nil {
+			logger(ctx).WarnContext(ctx, "Error finding challenge to record event", "reason", reason,
+				"err", fmt.Errorf("challenge for %s: %w", challengeRequest.DNSName, ErrChallengeNotFound))
+
+			return
+		}
+
+		e.recorder.Event(challenge, eventType, reason, message)
+	}()
+}
+
+// findChallenge returns the request's Challenge from the cache: the
+// Challenge with its key and DNS name in the request's ResourceNamespace or,
+// for ClusterIssuers, in any namespace
+func (e *challengeEvents) findChallenge(challengeRequest *v1alpha1.ChallengeRequest) (*cmacmev1.Challenge, error) {
+	if !e.synced() {
+		return nil, fmt.Errorf("challenge cache not synced, %w", ErrChallengeNotFound)
+	}
+
+	objs, err := e.challenges.ByIndex(challengeKeyIndex, challengeRequest.Key+" "+challengeRequest.DNSName)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up challenge: %w", err)
+	}
+
+	var clusterIssued *cmacmev1.Challenge
+
+	for _, obj := range objs {
+		challenge, ok := obj.(*cmacmev1.Challenge)
+
+		switch {
+		case !ok:
+		case challenge.Namespace == challengeRequest.ResourceNamespace:
+			return challenge, nil
+		case challenge.Spec.IssuerRef.Kind == cmapiv1.ClusterIssuerKind:
+			clusterIssued = challenge
+		}
+	}
+
+	if clusterIssued != nil {
+		return clusterIssued, nil
+	}
+
+	return nil, fmt.Errorf("challenge for %s: %w", challengeRequest.DNSName, ErrChallengeNotFound)
+}
+
+// redactMessage removes API keys from an Event message, Events are readable
+// by anyone allowed to read the Challenge
+func redactMessage(message string) string {
+	return apiKeyPattern.ReplaceAllString(message, "${1}REDACTED")
+}
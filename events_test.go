@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmacmev1 "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+	cmapiv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	cmfake "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestChallengeEvents(
+	t *testing.T, challenges *cmfake.Clientset, recorder record.EventRecorder,
+) *challengeEvents {
+	t.Helper()
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	index, synced, err := newChallengeIndex(challenges, stopCh)
+	if err != nil {
+		t.Fatalf("newChallengeIndex() error = %v", err)
+	}
+
+	if !cache.WaitForCacheSync(t.Context().Done(), synced) {
+		t.Fatal("challenge cache did not sync")
+	}
+
+	return &challengeEvents{challenges: index, synced: synced, recorder: recorder}
+}
+
+// newTestChallengeResource returns the Challenge of a challenge request,
+// issued by an Issuer or ClusterIssuer of kind
+func newTestChallengeResource(
+	name string, namespace string, kind string, challengeRequest *v1alpha1.ChallengeRequest,
+) *cmacmev1.Challenge {
+	return &cmacmev1.Challenge{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: cmacmev1.ChallengeSpec{
+			Key:       challengeRequest.Key,
+			DNSName:   challengeRequest.DNSName,
+			IssuerRef: cmmeta.IssuerReference{Name: "netactuate", Kind: kind},
+		},
+	}
+}
+
+func TestChallengeEvents(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+
+	challenge := newTestChallenge("events", "events-key")
+	unknownKey := newTestChallenge("events-unknown-key", "events-key")
+	unknownKey.Config.Raw = []byte(`{"apiVersion": "v1", "apiKey": {"name": "netactuate-api-key", "key": "missing"}}`)
+
+	noChallenge := newTestChallenge("events-no-challenge", "events-key")
+
+	challenges := cmfake.NewClientset(
+		// a ClusterIssuer challenge, which lives outside the ResourceNamespace
+		newTestChallengeResource("events", "default", cmapiv1.ClusterIssuerKind, challenge),
+		newTestChallengeResource("events-unknown-key", "cert-manager", cmapiv1.IssuerKind, unknownKey),
+		// an Issuer challenge in another namespace is not the request's
+		newTestChallengeResource("events-no-challenge", "default", cmapiv1.IssuerKind, noChallenge),
+	)
+	recorder := record.NewFakeRecorder(10)
+	solver.events = newTestChallengeEvents(t, challenges, recorder)
+
+	err := solver.Present(challenge)
+	if err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+
+	err = solver.CleanUp(challenge)
+	if err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+
+	err = solver.CleanUp(challenge)
+	if err == nil {
+		t.Fatal("CleanUp() of a deleted record returned no error")
+	}
+
+	err = solver.Present(unknownKey)
+	if err == nil {
+		t.Fatal("Present() with a missing secret key returned no error")
+	}
+
+	// a challenge without a Challenge resource records nothing
+	err = solver.Present(noChallenge)
+	if err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+
+	want := []string{
+		"Normal Presented Presented TXT record _acme-challenge.events.example.com. in zone example.com., record ID 2",
+		"Normal CleanedUp Deleted TXT record _acme-challenge.events.example.com. from zone example.com., record ID 2",
+		"Warning CleanUpFailed no TXT record found",
+		"Warning CredentialsError",
+	}
+
+	close(recorder.Events)
+
+	var got []string
+	for event := range recorder.Events {
+		got = append(got, event)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("events = %q, want %d events", got, len(want))
+	}
+
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("event %d = %q, want prefix %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestChallengeEventsLateChallenge(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+	challenge := newTestChallenge("events-late", "events-key")
+
+	challenges := cmfake.NewClientset()
+	recorder := record.NewFakeRecorder(10)
+	solver.events = newTestChallengeEvents(t, challenges, recorder)
+
+	// the Challenge is not in the cache yet, Present does not wait for it
+	err := solver.Present(challenge)
+	if err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+
+	_, err = challenges.AcmeV1().Challenges("cert-manager").Create(t.Context(),
+		newTestChallengeResource("events-late", "cert-manager", cmapiv1.IssuerKind, challenge), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Normal Presented") {
+			t.Errorf("event = %q, want a Presented event", event)
+		}
+	case <-time.After(challengeLookupWait):
+		t.Error("no event recorded once the Challenge was cached")
+	}
+}
+
+func TestRedactMessage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{
+			name:    "no url",
+			message: "error response from netactuate api: 401 Unauthorized",
+			want:    "error response from netactuate api: 401 Unauthorized",
+		},
+		{
+			name:    "key only",
+			message: `Get "https://vapi2.netactuate.com/api/dns/zones?key=secret": EOF`,
+			want:    `Get "https://vapi2.netactuate.com/api/dns/zones?key=REDACTED": EOF`,
+		},
+		{
+			name:    "key between parameters",
+			message: `Post "https://vapi2.netactuate.com/api/dns/record?domain_id=1&key=secret&name=x": EOF`,
+			want:    `Post "https://vapi2.netactuate.com/api/dns/record?domain_id=1&key=REDACTED&name=x": EOF`,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := redactMessage(testCase.message); got != testCase.want {
+				t.Errorf("redactMessage() = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
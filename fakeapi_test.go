@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	fakeAPIKey = "fake-api-key"
+	fakeZone   = "example.com"
+	fakeZoneID = 1000
+)
+
+// fakeNetActuate is an in-memory NetActuate DNS API holding a single zone
+type fakeNetActuate struct {
+	*httptest.Server
+
+	records map[int]netactuate.DNSRecord
+	headers []http.Header
+	nextID  int
+	mu      sync.Mutex
+}
+
+func newFakeNetActuate(t *testing.T) *fakeNetActuate {
+	t.Helper()
+
+	api := &fakeNetActuate{
+		records: map[int]netactuate.DNSRecord{},
+		nextID:  1,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/dns/zones", api.zones)
+	mux.HandleFunc("POST /api/dns/record", api.postRecord)
+	mux.HandleFunc("GET /api/dns/records/{zone}", api.listRecords)
+	mux.HandleFunc("GET /api/dns/record/{id}", api.getRecord)
+	mux.HandleFunc("DELETE /api/dns/record/{id}", api.deleteRecord)
+
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		api.headers = append(api.headers, r.Header.Clone())
+		api.mu.Unlock()
+
+		if r.URL.Query().Get("key") != fakeAPIKey {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+func (api *fakeNetActuate) zones(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, netactuate.ZoneList{
+		Result: "success",
+		Code:   http.StatusOK,
+		Data:   []netactuate.ZoneSummary{{Name: fakeZone, Type: "NATIVE", ID: fakeZoneID}},
+	})
+}
+
+func (api *fakeNetActuate) postRecord(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("domain_id") != strconv.Itoa(fakeZoneID) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	api.mu.Lock()
+	record := netactuate.DNSRecord{
+		Name:       query.Get("name") + "." + fakeZone,
+		RecordType: query.Get("type"),
+		Content:    query.Get("record_content"),
+		ID:         api.nextID,
+	}
+	api.records[record.ID] = record
+	api.nextID++
+	api.mu.Unlock()
+
+	writeJSON(w, netactuate.Envelope[netactuate.DNSRecordPostResponseData]{
+		Result: "success",
+		Code:   http.StatusOK,
+		Data:   netactuate.DNSRecordPostResponseData{Name: record.Name, Content: record.Content, ID: record.ID},
+	})
+}
+
+func (api *fakeNetActuate) listRecords(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("zone") != strconv.Itoa(fakeZoneID) {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	writeJSON(w, netactuate.Envelope[[]netactuate.DNSRecord]{
+		Result: "success",
+		Code:   http.StatusOK,
+		Data:   api.recordList(),
+	})
+}
+
+func (api *fakeNetActuate) getRecord(w http.ResponseWriter, r *http.Request) {
+	recordID, _ := strconv.Atoi(r.PathValue("id"))
+
+	api.mu.Lock()
+	record, ok := api.records[recordID]
+	api.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	writeJSON(w, netactuate.Envelope[netactuate.DNSRecord]{Result: "success", Code: http.StatusOK, Data: record})
+}
+
+func (api *fakeNetActuate) deleteRecord(w http.ResponseWriter, r *http.Request) {
+	recordID, _ := strconv.Atoi(r.PathValue("id"))
+
+	api.mu.Lock()
+	_, ok := api.records[recordID]
+	delete(api.records, recordID)
+	api.mu.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	}
+
+	writeJSON(w, netactuate.ZoneList{Result: "success", Code: http.StatusOK})
+}
+
+// addRecord adds a TXT record to the zone, as another tool sharing the
+// account would
+func (api *fakeNetActuate) addRecord(name string, content string) {
+	api.putRecord(netactuate.DNSRecord{Name: name, RecordType: "TXT", Content: content})
+}
+
+// putRecord adds a record to the zone, or replaces the record with its ID,
+// and returns its ID
+func (api *fakeNetActuate) putRecord(record netactuate.DNSRecord) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if record.ID == 0 {
+		record.ID = api.nextID
+		api.nextID++
+	}
+
+	api.records[record.ID] = record
+
+	return record.ID
+}
+
+// recordList returns the records in the zone
+func (api *fakeNetActuate) recordList() []netactuate.DNSRecord {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	records := make([]netactuate.DNSRecord, 0, len(api.records))
+	for _, record := range api.records {
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// newTestSolver returns a solver using the fake API, with its API key in the
+// cert-manager namespace
+func newTestSolver(t *testing.T, api *fakeNetActuate) *customDNSProviderSolver {
+	t.Helper()
+
+	client := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "netactuate-api-key", Namespace: "cert-manager"},
+		Data:       map[string][]byte{"key": []byte(fakeAPIKey)},
+	})
+
+	return &customDNSProviderSolver{
+		client:  client,
+		api:     netactuate.NewClient(netactuate.WithBaseURL(api.URL), netactuate.WithRetries(0, 0)),
+		secrets: &secretCache{client: client},
+		settings: webhookSettings{
+			clusterResourceNamespace: "cert-manager",
+			groupName:                "acme.example.com",
+			ownerID:                  "test-cluster",
+		},
+	}
+}
+
+// newTestChallenge returns a challenge for name in the fake zone
+func newTestChallenge(name string, key string) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		Key:               key,
+		DNSName:           name + "." + fakeZone,
+		ResourceNamespace: "cert-manager",
+		ResolvedFQDN:      "_acme-challenge." + name + "." + fakeZone + ".",
+		ResolvedZone:      fakeZone + ".",
+		Config: &extapi.JSON{
+			Raw: []byte(`{"apiVersion": "v1", "apiKey": {"name": "netactuate-api-key", "key": "key"}}`),
+		},
+	}
+}
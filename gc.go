@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	cmclientset "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	challengeRecordPrefix = "_acme-challenge."
+
+	gcLeaseName          = leaseNamePrefix + "-gc"
+	gcLeaseDuration      = 15 * time.Second
+	gcLeaseRenewDeadline = 10 * time.Second
+	gcLeaseRetryPeriod   = 2 * time.Second
+)
+
+// startGarbageCollector runs the garbage collector on the replica elected
+// leader until stopCh is closed. Each leader runs a collection when it is
+// elected and then every gcInterval.
+func (c *customDNSProviderSolver) startGarbageCollector(
+	challenges cmclientset.Interface, stopCh <-chan struct{},
+) error {
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		c.settings.leaseNamespace,
+		gcLeaseName,
+		c.client.CoreV1(),
+		c.client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: c.settings.leaseIdentity},
+	)
+	if err != nil {
+		return fmt.Errorf("error creating garbage collector lock: %w", err)
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   gcLeaseDuration,
+		RenewDeadline:   gcLeaseRenewDeadline,
+		RetryPeriod:     gcLeaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            gcLeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				c.runGarbageCollector(ctx, challenges)
+			},
+			OnStoppedLeading: func() {
+				slog.InfoContext(context.Background(), "Garbage collector stopped leading")
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating garbage collector leader election: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	go func() {
+		// Run returns when leadership is lost, the replica then stands for
+		// election again
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+
+	slog.InfoContext(ctx, "Garbage collector started",
+		"zones", c.settings.gcZones,
+		"interval", c.settings.gcInterval,
+		"minAge", c.settings.gcMinAge,
+		"dryRun", c.settings.gcDryRun,
+	)
+
+	return nil
+}
+
+// runGarbageCollector collects garbage every gcInterval until ctx is done
+func (c *customDNSProviderSolver) runGarbageCollector(ctx context.Context, challenges cmclientset.Interface) {
+	slog.InfoContext(ctx, "Garbage collector elected leader")
+
+	ticker := time.NewTicker(c.settings.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.collectGarbage(ctx, challenges)
+		c.metrics.gcRun(err)
+
+		if err != nil {
+			slog.ErrorContext(ctx, "Error collecting stale challenge records", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectGarbage deletes the challenge records in the configured zones that
+// this webhook created more than gcMinAge ago and that belong to no live
+// Challenge, along with their owner markers. Records without a marker owned
+// by this webhook are never deleted. In dry run mode the records are only
+// logged.
+func (c *customDNSProviderSolver) collectGarbage(ctx context.Context, challenges cmclientset.Interface) error {
+	secret, err := c.secrets.get(ctx, c.settings.gcSecretNamespace, c.settings.gcSecretName)
+	if err != nil {
+		return fmt.Errorf("error getting garbage collector api key: %w", err)
+	}
+
+	apiKey, ok := secret.Data[c.settings.gcSecretKey]
+	if !ok {
+		return fmt.Errorf("secret key not found, namespace: %s name: %s, key: %s, %w",
+			c.settings.gcSecretNamespace, c.settings.gcSecretName, c.settings.gcSecretKey, ErrAPIKeyDecode)
+	}
+
+	// challenges are listed before the zones, so a record presented after
+	// the list is too young to be collected
+	live, err := liveChallengeKeys(ctx, challenges)
+	if err != nil {
+		return err
+	}
+
+	keys := apiKeys{primary: string(apiKey)}
+
+	var errs []error
+
+	for _, zone := range c.settings.gcZones {
+		err = c.collectZone(ctx, keys, zone, live)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("zone %s: %w", zone, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// collectZone collects the stale challenge records of a zone
+func (c *customDNSProviderSolver) collectZone(
+	ctx context.Context, keys apiKeys, zone string, live map[string]bool,
+) error {
+	records, err := c.listZoneRecords(ctx, keys, zone)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-c.settings.gcMinAge)
+
+	for _, marker := range records {
+		owner, ok := parseOwnerMarker(marker.Content)
+		if marker.RecordType != "TXT" || !ok || !c.owns(owner) || live[owner.keyHash] || owner.created.After(cutoff) {
+			continue
+		}
+
+		recordName := strings.TrimPrefix(marker.Name, ownerRecordPrefix)
+		if !strings.HasPrefix(recordName, challengeRecordPrefix) {
+			continue
+		}
+
+		err = c.collectRecord(ctx, keys, zone, marker, owner, recordName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectRecord deletes the challenge record belonging to a stale owner
+// marker, then the marker. It takes the same locks as Present and CleanUp,
+// and skips the marker if it was removed or changed while waiting for them.
+func (c *customDNSProviderSolver) collectRecord(
+	ctx context.Context,
+	keys apiKeys,
+	zone string,
+	marker netactuate.DNSRecord,
+	owner ownerMarker,
+	recordName string,
+) error {
+	ctx, unlock, err := c.lockRecord(ctx, &v1alpha1.ChallengeRequest{ResolvedFQDN: recordName, ResolvedZone: zone})
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	// the records are listed again, another replica may have cleaned them up
+	// while it held the locks
+	records, err := c.listZoneRecords(ctx, keys, zone)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(records, marker) {
+		slog.DebugContext(ctx, "Stale owner marker changed before it was collected", "markerID", marker.ID)
+
+		return nil
+	}
+
+	log := slog.With("name", recordName, "markerID", marker.ID, "created", owner.created, "dryRun", c.settings.gcDryRun)
+
+	toDelete := []netactuate.DNSRecord{}
+
+	for _, record := range records {
+		if record.RecordType == "TXT" && record.Name == recordName && keyHash(record.Content) == owner.keyHash {
+			toDelete = append(toDelete, record)
+		}
+	}
+
+	toDelete = append(toDelete, marker)
+
+	for _, record := range toDelete {
+		log.InfoContext(ctx, "Deleting stale challenge record", "id", record.ID)
+		c.metrics.gcRecordDeleted(c.settings.gcDryRun)
+
+		if c.settings.gcDryRun {
+			continue
+		}
+
+		err = c.deleteTXTRecord(ctx, keys, record)
+		if err != nil {
+			return fmt.Errorf("error deleting record %d: %w", record.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// listZoneRecords returns the records of zone
+func (c *customDNSProviderSolver) listZoneRecords(
+	ctx context.Context, keys apiKeys, zone string,
+) ([]netactuate.DNSRecord, error) {
+	var err error
+
+	var records []netactuate.DNSRecord
+
+	err = c.withAPIKey(ctx, keys, func(apiKey string) error {
+		records, err = c.api.DNSRecordsGet(ctx, apiKey, netactuate.GetDomainFromZone(zone))
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing records: %w", err)
+	}
+
+	return records, nil
+}
+
+// liveChallengeKeys returns the key hashes of all Challenges in the cluster
+func liveChallengeKeys(ctx context.Context, challenges cmclientset.Interface) (map[string]bool, error) {
+	list, err := challenges.AcmeV1().Challenges(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing challenges: %w", err)
+	}
+
+	live := make(map[string]bool, len(list.Items))
+
+	for i := range list.Items {
+		live[keyHash(list.Items[i].Spec.Key)] = true
+	}
+
+	return live, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	cmacmev1 "github.com/cert-manager/cert-manager/pkg/apis/acme/v1"
+	cmfake "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned/fake"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		dryRun bool
+	}{
+		{name: "delete", dryRun: false},
+		{name: "dry run", dryRun: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			api := newFakeNetActuate(t)
+			solver := newTestSolver(t, api)
+			solver.settings.gcZones = []string{fakeZone}
+			solver.settings.gcMinAge = time.Hour
+			solver.settings.gcDryRun = testCase.dryRun
+			solver.settings.gcSecretNamespace = "cert-manager"
+			solver.settings.gcSecretName = "netactuate-api-key"
+			solver.settings.gcSecretKey = "key"
+
+			metrics, err := newSolverMetrics(prometheus.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			solver.metrics = metrics
+
+			old := time.Now().Add(-2 * time.Hour)
+			addChallenge := func(name string, key string, marker ownerMarker) {
+				api.addRecord("_acme-challenge."+name+"."+fakeZone, key)
+				api.addRecord("_acme-owner._acme-challenge."+name+"."+fakeZone, marker.String())
+			}
+
+			stale := solver.newOwnerMarker("stale-key")
+			stale.created = old
+			addChallenge("stale", "stale-key", stale)
+
+			live := solver.newOwnerMarker("live-key")
+			live.created = old
+			addChallenge("live", "live-key", live)
+
+			addChallenge("young", "young-key", solver.newOwnerMarker("young-key"))
+
+			otherCluster := solver.newOwnerMarker("other-key")
+			otherCluster.created = old
+			otherCluster.cluster = "other-cluster"
+			addChallenge("other", "other-key", otherCluster)
+
+			api.addRecord("_acme-challenge.unmarked."+fakeZone, "unmarked-key")
+
+			challenges := cmfake.NewClientset(&cmacmev1.Challenge{
+				ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "default"},
+				Spec:       cmacmev1.ChallengeSpec{Key: "live-key"},
+			})
+
+			err = solver.collectGarbage(t.Context(), challenges)
+			if err != nil {
+				t.Fatalf("collectGarbage() error = %v", err)
+			}
+
+			var contents []string
+			for _, record := range api.recordList() {
+				contents = append(contents, record.Content)
+			}
+
+			for _, want := range []string{"live-key", "young-key", "other-key", "unmarked-key"} {
+				if !slices.Contains(contents, want) {
+					t.Errorf("record %s was collected", want)
+				}
+			}
+
+			if slices.Contains(contents, "stale-key") != testCase.dryRun {
+				t.Errorf("stale record present = %v, want %v", !testCase.dryRun, testCase.dryRun)
+			}
+
+			if slices.Contains(contents, stale.String()) != testCase.dryRun {
+				t.Errorf("stale marker present = %v, want %v", !testCase.dryRun, testCase.dryRun)
+			}
+
+			deleted := testutil.ToFloat64(metrics.gcRecordsDeleted.WithLabelValues(strconv.FormatBool(testCase.dryRun)))
+			if deleted != 2 {
+				t.Errorf("records deleted = %v, want 2", deleted)
+			}
+		})
+	}
+}
+
+func TestCollectGarbageLocks(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+	solver.settings.gcZones = []string{fakeZone}
+	solver.settings.gcMinAge = time.Hour
+	solver.settings.gcSecretNamespace = "cert-manager"
+	solver.settings.gcSecretName = "netactuate-api-key"
+	solver.settings.gcSecretKey = "key"
+
+	metrics, err := newSolverMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	solver.metrics = metrics
+
+	leaseClient := fake.NewClientset()
+	solver.leases = newTestLeaseLocker(t, leaseClient, "replica-a")
+	otherReplica := newTestLeaseLocker(t, leaseClient, "replica-b")
+
+	stale := solver.newOwnerMarker("stale-key")
+	stale.created = time.Now().Add(-2 * time.Hour)
+	recordID := api.putRecord(netactuate.DNSRecord{
+		Name: "_acme-challenge.stale." + fakeZone, RecordType: "TXT", Content: "stale-key",
+	})
+	markerID := api.putRecord(netactuate.DNSRecord{
+		Name: "_acme-owner._acme-challenge.stale." + fakeZone, RecordType: "TXT", Content: stale.String(),
+	})
+
+	// another replica holds the zone's Lease while it cleans up the record
+	_, unlockOther, err := otherReplica.lock(t.Context(), fakeZone)
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	collected := make(chan error)
+
+	go func() {
+		collected <- solver.collectGarbage(t.Context(), cmfake.NewClientset())
+	}()
+
+	select {
+	case err = <-collected:
+		t.Fatalf("collectGarbage() = %v while another replica held the lease", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	api.mu.Lock()
+	delete(api.records, recordID)
+	delete(api.records, markerID)
+	api.mu.Unlock()
+
+	unlockOther()
+
+	err = <-collected
+	if err != nil {
+		t.Fatalf("collectGarbage() error = %v", err)
+	}
+
+	deleted := testutil.ToFloat64(metrics.gcRecordsDeleted.WithLabelValues("false"))
+	if deleted != 0 {
+		t.Errorf("records deleted = %v, want 0", deleted)
+	}
+}
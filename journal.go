@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	journalName          = leaseNamePrefix + "-journal"
+	journalWriteTimeout  = 10 * time.Second
+	journalReplayPeriod  = time.Minute
+	journalActionPresent = "present"
+	journalActionCleanUp = "cleanup"
+
+	journalStepStarted       = "started"
+	journalStepMarkerAdded   = "marker-added"
+	journalStepRecordAdded   = "record-added"
+	journalStepRecordDeleted = "record-deleted"
+
+	// journalStaleAge is how long another replica's entry is left alone, no
+	// Present or CleanUp takes longer
+	journalStaleAge = 2 * maxTimeout
+)
+
+// journal records the record changes in flight in a ConfigMap, one entry per
+// challenge, keyed by its challengeID, so changes interrupted by a crash can be rolled back or
+// finished when the webhook restarts. An entry is written before the first
+// record is changed, updated after each change and removed when Present or
+// CleanUp returns. A nil *journal records nothing.
+type journal struct {
+	client    kubernetes.Interface
+	namespace string
+	identity  string
+
+	// afterWrite is called after every write, tests use it to simulate a
+	// crash at each step
+	afterWrite func(entry journalEntry)
+}
+
+// journalEntry is an in flight Present or CleanUp. It holds what is needed to
+// find the challenge's records again, record IDs are kept for operators.
+type journalEntry struct {
+	Started           time.Time    `json:"started"`
+	Config            *extapi.JSON `json:"config,omitempty"`
+	ID                string       `json:"id"`
+	Holder            string       `json:"holder"`
+	Action            string       `json:"action"`
+	Step              string       `json:"step"`
+	ResourceNamespace string       `json:"resourceNamespace"`
+	DNSName           string       `json:"dnsName"`
+	Zone              string       `json:"zone"`
+	FQDN              string       `json:"fqdn"`
+	Key               string       `json:"key"`
+	RecordID          int          `json:"recordID,omitempty"`
+	MarkerID          int          `json:"markerID,omitempty"`
+}
+
+// start writes the entry for a Present or CleanUp that is about to change
+// records. The entry must be written before any change is made.
+func (j *journal) start(
+	ctx context.Context, action string, challengeRequest *v1alpha1.ChallengeRequest,
+) (*journalEntry, error) {
+	if j == nil {
+		return nil, nil //nolint:nilnil // a nil journal has no entries
+	}
+
+	entry := &journalEntry{
+		Started:           time.Now(),
+		Config:            challengeRequest.Config,
+		ID:                challengeID(challengeRequest),
+		Holder:            j.identity,
+		Action:            action,
+		Step:              journalStepStarted,
+		ResourceNamespace: challengeRequest.ResourceNamespace,
+		DNSName:           challengeRequest.DNSName,
+		Zone:              challengeRequest.ResolvedZone,
+		FQDN:              challengeRequest.ResolvedFQDN,
+		Key:               challengeRequest.Key,
+	}
+
+	err := j.write(ctx, *entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// step records that an entry's operation completed a step. The entry is
+// already enough to recover, so failing to record a step is only logged.
+func (j *journal) step(ctx context.Context, entry *journalEntry, step string, recordID int) {
+	if j == nil || entry == nil {
+		return
+	}
+
+	entry.Step = step
+
+	switch step {
+	case journalStepMarkerAdded:
+		entry.MarkerID = recordID
+	default:
+		entry.RecordID = recordID
+	}
+
+	err := j.write(ctx, *entry)
+	if err != nil {
+		logger(ctx).WarnContext(ctx, "Error updating journal", "step", step, "err", err)
+	}
+}
+
+// finish removes an entry once its operation returned opErr, and returns
+// opErr. If the operation succeeded but its entry cannot be removed, the
+// operation fails, as replay would roll back a completed Present.
+func (j *journal) finish(ctx context.Context, entry *journalEntry, opErr error) error {
+	if j == nil || entry == nil {
+		return opErr
+	}
+
+	err := j.update(ctx, func(data map[string]string) {
+		delete(data, entry.ID)
+	})
+	if err != nil {
+		logger(ctx).WarnContext(ctx, "Error removing journal entry", "err", err)
+
+		if opErr == nil {
+			return fmt.Errorf("error finishing journal entry: %w", err)
+		}
+	}
+
+	return opErr
+}
+
+func (j *journal) write(ctx context.Context, entry journalEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding journal entry: %w", err)
+	}
+
+	err = j.update(ctx, func(data map[string]string) {
+		data[entry.ID] = string(value)
+	})
+	if err != nil {
+		return err
+	}
+
+	if j.afterWrite != nil {
+		j.afterWrite(entry)
+	}
+
+	return nil
+}
+
+// update applies change to the journal's entries, creating the ConfigMap if
+// it does not exist. Updates from other replicas are retried.
+func (j *journal) update(ctx context.Context, change func(data map[string]string)) error {
+	// a change that was made must be recorded even if the challenge timed out
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), journalWriteTimeout)
+	defer cancel()
+
+	configMaps := j.client.CoreV1().ConfigMaps(j.namespace)
+
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		configMap, err := configMaps.Get(ctx, journalName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: journalName, Namespace: j.namespace},
+				Data:       map[string]string{},
+			}
+			change(configMap.Data)
+
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+
+			return err //nolint:wrapcheck // wrapped below
+		}
+
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped below
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+
+		change(configMap.Data)
+
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+
+		return err //nolint:wrapcheck // wrapped below
+	})
+	if err != nil {
+		return fmt.Errorf("error updating journal: %w", err)
+	}
+
+	return nil
+}
+
+// get returns the entry with id, or nil if there is none
+func (j *journal) get(ctx context.Context, id string) (*journalEntry, error) {
+	if j == nil {
+		return nil, nil //nolint:nilnil // a nil journal has no entries
+	}
+
+	configMap, err := j.client.CoreV1().ConfigMaps(j.namespace).Get(ctx, journalName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil //nolint:nilnil // no journal, no entry
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+
+	value, found := configMap.Data[id]
+	if !found {
+		return nil, nil //nolint:nilnil // the entry was removed
+	}
+
+	var entry journalEntry
+
+	err = json.Unmarshal([]byte(value), &entry)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding journal entry %s: %w", id, err)
+	}
+
+	return &entry, nil
+}
+
+// entries returns the journal's entries
+func (j *journal) entries(ctx context.Context) ([]journalEntry, error) {
+	if j == nil {
+		return nil, nil
+	}
+
+	configMap, err := j.client.CoreV1().ConfigMaps(j.namespace).Get(ctx, journalName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+
+	entries := make([]journalEntry, 0, len(configMap.Data))
+
+	for id, value := range configMap.Data {
+		var entry journalEntry
+
+		err = json.Unmarshal([]byte(value), &entry)
+		if err != nil {
+			slog.WarnContext(ctx, "Ignoring invalid journal entry", "id", id, "err", err)
+
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// challengeRequest rebuilds the request of an entry's operation
+func (e journalEntry) challengeRequest() *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		Key:               e.Key,
+		ResourceNamespace: e.ResourceNamespace,
+		DNSName:           e.DNSName,
+		ResolvedFQDN:      e.FQDN,
+		ResolvedZone:      e.Zone,
+		Config:            e.Config,
+	}
+}
+
+// startJournalReplay replays the journal now, including this replica's own
+// entries left by its previous process, and then periodically until stopCh is
+// closed, to recover the entries of replicas that crashed after this one
+// started. It is called before the solver serves, so none of this replica's
+// own operations can be in flight during the first replay.
+func (c *customDNSProviderSolver) startJournalReplay(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.replayJournal(ctx, true)
+
+	go func() {
+		defer cancel()
+
+		ticker := time.NewTicker(journalReplayPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				c.replayJournal(ctx, false)
+			}
+		}
+	}()
+}
+
+// replayJournal recovers the operations left in the journal by a crash. The
+// records of an interrupted Present are rolled back, cert-manager calls
+// Present again as it never saw it succeed, and an interrupted CleanUp is
+// finished. Both delete whatever records of the challenge exist. An entry is
+// only recovered once no operation could still be running, except for this
+// replica's own entries on startup, before it runs any operation.
+func (c *customDNSProviderSolver) replayJournal(ctx context.Context, startup bool) {
+	entries, err := c.journal.entries(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error replaying journal", "err", err)
+
+		return
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+
+		own := startup && entry.Holder == c.journal.identity
+		if !own && time.Since(entry.Started) < journalStaleAge {
+			continue
+		}
+
+		challengeRequest := entry.challengeRequest()
+		entryCtx := withChallengeLogger(ctx, "replay", challengeRequest)
+
+		logger(entryCtx).InfoContext(entryCtx, "Recovering interrupted operation",
+			"journalAction", entry.Action,
+			"step", entry.Step,
+			"holder", entry.Holder,
+			"recordID", entry.RecordID,
+			"markerID", entry.MarkerID,
+		)
+
+		err = c.recoverEntry(entryCtx, entry)
+		if err != nil {
+			logger(entryCtx).ErrorContext(entryCtx, "Error recovering interrupted operation", "err", err)
+		}
+	}
+}
+
+// recoverEntry deletes the records of an interrupted operation's challenge
+// and removes its entry. The entry is read again once the record is locked,
+// it is left alone if its operation returned, or a new one started, since it
+// was listed.
+func (c *customDNSProviderSolver) recoverEntry(ctx context.Context, entry *journalEntry) error {
+	challengeRequest := entry.challengeRequest()
+
+	cfg, err := loadConfig(challengeRequest.Config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout.Duration)
+	defer cancel()
+
+	keys, err := c.loadAPIKey(ctx, cfg, challengeRequest)
+	if err != nil {
+		return err
+	}
+
+	ctx, unlock, err := c.lockRecord(ctx, challengeRequest)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	current, err := c.journal.get(ctx, entry.ID)
+	if err != nil {
+		return err
+	}
+
+	if current == nil || current.Holder != entry.Holder || !current.Started.Equal(entry.Started) {
+		logger(ctx).InfoContext(ctx, "Journal entry changed while waiting for the record lock, skipping")
+
+		return nil
+	}
+
+	_, err = c.deleteChallengeRecords(ctx, keys, challengeRequest, nil)
+
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrTXTRecordNotFound):
+		// the operation was interrupted before a record was added, or after
+		// all were deleted
+	case errors.Is(err, ErrRecordNotOwned):
+		// retrying would never succeed, the record is left for an operator
+		logger(ctx).WarnContext(ctx, "Dropping journal entry of a record without owner marker")
+	default:
+		return err
+	}
+
+	return c.journal.finish(ctx, entry, nil)
+}
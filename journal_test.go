@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var errCrash = errors.New("crashed")
+
+func newTestJournal(solver *customDNSProviderSolver, identity string) *journal {
+	return &journal{client: solver.client, namespace: "cert-manager", identity: identity}
+}
+
+func TestJournalReplayAfterCrash(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		action    string
+		crashStep string
+	}{
+		{name: "present before marker", action: journalActionPresent, crashStep: journalStepStarted},
+		{name: "present after marker", action: journalActionPresent, crashStep: journalStepMarkerAdded},
+		{name: "present after record", action: journalActionPresent, crashStep: journalStepRecordAdded},
+		{name: "cleanup before record", action: journalActionCleanUp, crashStep: journalStepStarted},
+		{name: "cleanup after record", action: journalActionCleanUp, crashStep: journalStepRecordDeleted},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			api := newFakeNetActuate(t)
+			solver := newTestSolver(t, api)
+			solver.journal = newTestJournal(solver, "replica-a")
+			challenge := newTestChallenge("journal", "journal-key")
+
+			if testCase.action == journalActionCleanUp {
+				err := solver.Present(challenge)
+				if err != nil {
+					t.Fatalf("Present() error = %v", err)
+				}
+			}
+
+			// once crashed, the replica makes no more changes to the journal
+			var crashed atomic.Bool
+
+			client, _ := solver.client.(*fake.Clientset)
+			client.PrependReactor("*", "configmaps",
+				func(k8stesting.Action) (bool, runtime.Object, error) {
+					if crashed.Load() {
+						return true, nil, errCrash
+					}
+
+					return false, nil, nil
+				},
+			)
+
+			solver.journal.afterWrite = func(entry journalEntry) {
+				if entry.Action == testCase.action && entry.Step == testCase.crashStep {
+					crashed.Store(true)
+					panic(errCrash)
+				}
+			}
+
+			func() {
+				defer func() {
+					recovered, _ := recover().(error)
+					if !errors.Is(recovered, errCrash) {
+						t.Fatalf("%s did not crash at %s, recovered %v", testCase.action, testCase.crashStep, recovered)
+					}
+				}()
+
+				if testCase.action == journalActionPresent {
+					_ = solver.Present(challenge)
+				} else {
+					_ = solver.CleanUp(challenge)
+				}
+			}()
+
+			// the replica restarts
+			crashed.Store(false)
+
+			restarted := newTestSolver(t, api)
+			restarted.client = client
+			restarted.journal = newTestJournal(solver, "replica-a")
+
+			entries, err := restarted.journal.entries(t.Context())
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("journal entries before replay = %v, %v, want 1 entry", entries, err)
+			}
+
+			restarted.replayJournal(t.Context(), true)
+
+			if records := api.recordList(); len(records) != 0 {
+				t.Errorf("records after replay = %v, want none", records)
+			}
+
+			entries, err = restarted.journal.entries(t.Context())
+			if err != nil || len(entries) != 0 {
+				t.Errorf("journal entries after replay = %v, %v, want none", entries, err)
+			}
+		})
+	}
+}
+
+func TestJournalReplayOtherReplica(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+	replicaB := newTestJournal(solver, "replica-b")
+	challenge := newTestChallenge("journal-other", "journal-key")
+
+	api.addRecord("_acme-owner._acme-challenge.journal-other."+fakeZone, solver.newOwnerMarker("journal-key").String())
+
+	entry, err := replicaB.start(t.Context(), journalActionPresent, challenge)
+	if err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+
+	// replica-b may still be presenting the record
+	solver.journal = newTestJournal(solver, "replica-a")
+	solver.replayJournal(t.Context(), false)
+
+	if records := api.recordList(); len(records) != 1 {
+		t.Errorf("records after replay = %v, want the marker", records)
+	}
+
+	// replica-b's entry is recovered once it is stale
+	entry.Started = time.Now().Add(-journalStaleAge)
+
+	err = replicaB.write(t.Context(), *entry)
+	if err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	solver.replayJournal(t.Context(), false)
+
+	if records := api.recordList(); len(records) != 0 {
+		t.Errorf("records after replay of a stale entry = %v, want none", records)
+	}
+
+	entries, err := solver.journal.entries(t.Context())
+	if err != nil || len(entries) != 0 {
+		t.Errorf("journal entries after replay = %v, %v, want none", entries, err)
+	}
+}
+
+func TestJournalReplayDuringPresent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		startup bool
+	}{
+		{name: "periodic", startup: false},
+		// the replay lists the entry and then waits for the record lock
+		// until Present returned
+		{name: "own entries", startup: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			api := newFakeNetActuate(t)
+			solver := newTestSolver(t, api)
+			solver.journal = newTestJournal(solver, "replica-a")
+			challenge := newTestChallenge("journal-inflight", "journal-key")
+
+			var replaying atomic.Bool
+
+			listed := make(chan struct{})
+
+			client, _ := solver.client.(*fake.Clientset)
+			client.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+				if replaying.CompareAndSwap(true, false) {
+					close(listed)
+				}
+
+				return false, nil, nil
+			})
+
+			replayed := make(chan struct{})
+
+			solver.journal.afterWrite = func(entry journalEntry) {
+				if entry.Step != journalStepRecordAdded {
+					return
+				}
+
+				replaying.Store(true)
+
+				go func() {
+					defer close(replayed)
+
+					solver.replayJournal(t.Context(), testCase.startup)
+				}()
+
+				<-listed
+			}
+
+			err := solver.Present(challenge)
+			if err != nil {
+				t.Fatalf("Present() error = %v", err)
+			}
+
+			<-replayed
+
+			if records := api.recordList(); len(records) != 2 {
+				t.Errorf("records after replay = %v, want the record and its marker", records)
+			}
+
+			entries, err := solver.journal.entries(t.Context())
+			if err != nil || len(entries) != 0 {
+				t.Errorf("journal entries after Present = %v, %v, want none", entries, err)
+			}
+		})
+	}
+}
+
+func TestJournalEntryKeys(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+	solver.journal = newTestJournal(solver, "replica-a")
+
+	// two challenges for one name, as for a domain and its wildcard, without
+	// the UID cert-manager leaves empty
+	challenges := []*v1alpha1.ChallengeRequest{
+		newTestChallenge("journal-keys", "first-key"),
+		newTestChallenge("journal-keys", "second-key"),
+	}
+
+	for _, challenge := range challenges {
+		_, err := solver.journal.start(t.Context(), journalActionPresent, challenge)
+		if err != nil {
+			t.Fatalf("start() error = %v", err)
+		}
+	}
+
+	configMap, err := solver.client.CoreV1().ConfigMaps("cert-manager").Get(t.Context(), journalName,
+		metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(configMap.Data) != len(challenges) {
+		t.Errorf("journal entries = %v, want one per challenge", configMap.Data)
+	}
+
+	for key := range configMap.Data {
+		if errs := validation.IsConfigMapKey(key); len(errs) != 0 {
+			t.Errorf("journal entry key %q is not a valid ConfigMap key: %v", key, errs)
+		}
+	}
+}
+
+func TestJournalFinishFailure(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+	solver.journal = newTestJournal(solver, "replica-a")
+	challenge := newTestChallenge("journal-finish", "journal-key")
+
+	// the update removing the entry fails
+	client, _ := solver.client.(*fake.Clientset)
+	client.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update, _ := action.(k8stesting.UpdateAction)
+
+		configMap, _ := update.GetObject().(*corev1.ConfigMap)
+		if _, found := configMap.Data[challengeID(challenge)]; !found {
+			return true, nil, errCrash
+		}
+
+		return false, nil, nil
+	})
+
+	// Present fails rather than leave an entry replay would roll back
+	err := solver.Present(challenge)
+	if !errors.Is(err, errCrash) {
+		t.Errorf("Present() error = %v, want %v", err, errCrash)
+	}
+
+	entries, err := solver.journal.entries(t.Context())
+	if err != nil || len(entries) != 1 {
+		t.Errorf("journal entries = %v, %v, want the entry of the failed Present", entries, err)
+	}
+}
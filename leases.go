@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	leaseModeZone   = "zone"
+	leaseModeGlobal = "global"
+
+	leaseFallbackFail    = "fail"
+	leaseFallbackProceed = "proceed"
+
+	leaseNamePrefix     = "netactuate-webhook"
+	leaseReleaseTimeout = 5 * time.Second
+)
+
+// leaseLocker coordinates record changes between webhook replicas with
+// Kubernetes Leases, either one Lease per zone or a single global Lease. A
+// Lease is held by a single replica, other replicas wait until it is released
+// or expires. Held Leases are renewed until they are released, and the change
+// they guard is canceled if they are lost. A Lease only tells replicas apart,
+// so the callers of this replica take turns holding it.
+type leaseLocker struct {
+	client        kubernetes.Interface
+	metrics       *solverMetrics
+	local         recordLocks
+	mode          string
+	namespace     string
+	identity      string
+	fallback      string
+	duration      time.Duration
+	retryInterval time.Duration
+}
+
+// lock waits until the Lease for zone is held or ctx is done, and returns a
+// context that is canceled with ErrLeaseLost if the Lease is lost, and the
+// function that releases it. If the Lease cannot be read or written and the
+// fallback is proceed, the change goes ahead without it.
+func (l *leaseLocker) lock(ctx context.Context, zone string) (context.Context, func(), error) {
+	if l == nil {
+		return ctx, func() {}, nil
+	}
+
+	name := l.leaseName(zone)
+	start := time.Now()
+	contended := false
+
+	unlockLocal, err := l.local.lock(ctx, name)
+	if err != nil {
+		l.metrics.leaseWait("timeout", time.Since(start))
+
+		return nil, nil, err
+	}
+
+	for {
+		lease, acquired, err := l.tryAcquire(ctx, name)
+
+		switch {
+		case err != nil && l.fallback == leaseFallbackProceed:
+			l.metrics.leaseWait("fallback", time.Since(start))
+			logger(ctx).WarnContext(ctx, "Error acquiring lease, proceeding without it", "lease", name, "err", err)
+
+			return ctx, unlockLocal, nil
+		case err != nil:
+			unlockLocal()
+			l.metrics.leaseWait("error", time.Since(start))
+
+			return nil, nil, fmt.Errorf("error acquiring lease %s: %w", name, err)
+		case acquired:
+			l.metrics.leaseWait("acquired", time.Since(start))
+			logger(ctx).DebugContext(ctx, "Lease acquired", "lease", name, "wait", time.Since(start))
+
+			heldCtx, cancel := context.WithCancelCause(ctx)
+			release := l.hold(ctx, lease, cancel)
+
+			return heldCtx, func() {
+				release()
+				cancel(nil)
+				unlockLocal()
+			}, nil
+		}
+
+		if !contended {
+			contended = true
+
+			l.metrics.leaseContended(l.mode)
+			logger(ctx).InfoContext(ctx, "Waiting for lease held by another replica", "lease", name)
+		}
+
+		select {
+		case <-ctx.Done():
+			unlockLocal()
+			l.metrics.leaseWait("timeout", time.Since(start))
+
+			return nil, nil, fmt.Errorf("error waiting for lease %s: %w", name, ctx.Err())
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+// tryAcquire takes the Lease if it is free or expired. It reports false if
+// another replica holds it, or won the race to take it.
+func (l *leaseLocker) tryAcquire(ctx context.Context, name string) (*coordinationv1.Lease, bool, error) {
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: l.namespace},
+			Spec:       l.heldSpec(now, 0),
+		}, metav1.CreateOptions{})
+
+		switch {
+		case apierrors.IsAlreadyExists(err):
+			return nil, false, nil
+		case err != nil:
+			return nil, false, fmt.Errorf("error creating lease: %w", err)
+		default:
+			return lease, true, nil
+		}
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting lease: %w", err)
+	}
+
+	if l.heldByOther(lease, now.Time) {
+		return nil, false, nil
+	}
+
+	transitions := ptr.Deref(lease.Spec.LeaseTransitions, 0)
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != l.identity {
+		transitions++
+	}
+
+	lease.Spec = l.heldSpec(now, transitions)
+
+	lease, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+
+	switch {
+	case apierrors.IsConflict(err):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, fmt.Errorf("error updating lease: %w", err)
+	default:
+		return lease, true, nil
+	}
+}
+
+// heldByOther reports whether another replica holds an unexpired lease
+func (l *leaseLocker) heldByOther(lease *coordinationv1.Lease, now time.Time) bool {
+	holder := ptr.Deref(lease.Spec.HolderIdentity, "")
+	if holder == "" || holder == l.identity || lease.Spec.RenewTime == nil {
+		return false
+	}
+
+	duration := time.Duration(ptr.Deref(lease.Spec.LeaseDurationSeconds, 0)) * time.Second
+
+	return now.Before(lease.Spec.RenewTime.Add(duration))
+}
+
+func (l *leaseLocker) heldSpec(now metav1.MicroTime, transitions int32) coordinationv1.LeaseSpec {
+	return coordinationv1.LeaseSpec{
+		HolderIdentity:       ptr.To(l.identity),
+		LeaseDurationSeconds: ptr.To(int32(l.duration.Seconds())),
+		AcquireTime:          &now,
+		RenewTime:            &now,
+		LeaseTransitions:     ptr.To(transitions),
+	}
+}
+
+// hold renews the Lease until the returned function is called, which
+// releases it. If another replica took the Lease, or it expired because it
+// could not be renewed, renewing stops and lost is called with ErrLeaseLost.
+func (l *leaseLocker) hold(
+	ctx context.Context, lease *coordinationv1.Lease, lost context.CancelCauseFunc,
+) func() {
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	held := true
+
+	// the Lease is released even if the challenge timed out
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(l.duration / 3)
+		defer ticker.Stop()
+
+		renewed := time.Now()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			current, err := l.renew(ctx, lease)
+
+			switch {
+			case errors.Is(err, ErrLeaseLost):
+				logger(ctx).ErrorContext(ctx, "Lease lost to another replica", "lease", lease.Name)
+			case err != nil && time.Since(renewed) >= l.duration:
+				logger(ctx).ErrorContext(ctx, "Lease expired before it could be renewed", "lease", lease.Name, "err", err)
+			case err != nil:
+				logger(ctx).WarnContext(ctx, "Error renewing lease", "lease", lease.Name, "err", err)
+
+				continue
+			default:
+				lease = current
+				renewed = time.Now()
+
+				continue
+			}
+
+			held = false
+
+			lost(ErrLeaseLost)
+
+			return
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+
+		// a lost Lease is no longer this replica's to release
+		if !held {
+			return
+		}
+
+		releaseCtx, cancel := context.WithTimeout(ctx, leaseReleaseTimeout)
+		defer cancel()
+
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.RenewTime = nil
+		lease.Spec.AcquireTime = nil
+
+		_, err := leases.Update(releaseCtx, lease, metav1.UpdateOptions{})
+		if err != nil {
+			// the Lease expires on its own if it cannot be released
+			logger(ctx).WarnContext(ctx, "Error releasing lease", "lease", lease.Name, "err", err)
+		}
+	}
+}
+
+// renew extends the Lease. If the Lease changed since it was last written, it
+// is read again, and renewed if this replica still holds it. It returns
+// ErrLeaseLost if another replica holds it.
+func (l *leaseLocker) renew(ctx context.Context, lease *coordinationv1.Lease) (*coordinationv1.Lease, error) {
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+	renewing := lease.DeepCopy()
+	renewing.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now()))
+
+	renewed, err := leases.Update(ctx, renewing, metav1.UpdateOptions{})
+	if !apierrors.IsConflict(err) {
+		if err != nil {
+			return nil, fmt.Errorf("error updating lease: %w", err)
+		}
+
+		return renewed, nil
+	}
+
+	current, err := leases.Get(ctx, lease.Name, metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, ErrLeaseLost
+	case err != nil:
+		return nil, fmt.Errorf("error getting lease: %w", err)
+	case ptr.Deref(current.Spec.HolderIdentity, "") != l.identity:
+		return nil, ErrLeaseLost
+	}
+
+	current.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now()))
+
+	renewed, err = leases.Update(ctx, current, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error updating lease: %w", err)
+	}
+
+	return renewed, nil
+}
+
+// leaseName returns the name of the Lease guarding zone. Zones whose names
+// are not valid object names are hashed.
+func (l *leaseLocker) leaseName(zone string) string {
+	if l.mode == leaseModeGlobal {
+		return leaseNamePrefix
+	}
+
+	name := leaseNamePrefix + "." + normalizeZone(zone)
+	if len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(normalizeZone(zone)))
+
+	return leaseNamePrefix + "." + hex.EncodeToString(sum[:8])
+}
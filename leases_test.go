@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+var errLeaseAPI = errors.New("lease api unavailable")
+
+func newTestLeaseLocker(t *testing.T, client *fake.Clientset, identity string) *leaseLocker {
+	t.Helper()
+
+	metrics, err := newSolverMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &leaseLocker{
+		client:        client,
+		metrics:       metrics,
+		mode:          leaseModeZone,
+		namespace:     "cert-manager",
+		identity:      identity,
+		fallback:      leaseFallbackFail,
+		duration:      time.Second,
+		retryInterval: 10 * time.Millisecond,
+	}
+}
+
+func getTestLease(t *testing.T, client *fake.Clientset, name string) *coordinationv1.Lease {
+	t.Helper()
+
+	lease, err := client.CoordinationV1().Leases("cert-manager").Get(t.Context(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("error getting lease %s: %v", name, err)
+	}
+
+	return lease
+}
+
+func TestLeaseLockerContention(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	replicaA := newTestLeaseLocker(t, client, "replica-a")
+	replicaB := newTestLeaseLocker(t, client, "replica-b")
+
+	_, unlockA, err := replicaA.lock(t.Context(), "example.com.")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err = replicaB.lock(ctx, "Example.com")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock() of a held lease error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if got := testutil.ToFloat64(replicaB.metrics.leaseContentions.WithLabelValues(leaseModeZone)); got != 1 {
+		t.Errorf("lease contentions = %v, want 1", got)
+	}
+
+	// another zone has its own lease
+	_, unlockOther, err := replicaB.lock(t.Context(), "example.org.")
+	if err != nil {
+		t.Fatalf("lock() of another zone error = %v", err)
+	}
+
+	unlockOther()
+
+	// renewals keep the lease from expiring while it is held
+	time.Sleep(1500 * time.Millisecond)
+
+	lease := getTestLease(t, client, "netactuate-webhook.example.com")
+	if time.Since(lease.Spec.RenewTime.Time) > time.Second {
+		t.Errorf("lease renewed at %v, not renewed while held", lease.Spec.RenewTime)
+	}
+
+	unlockA()
+
+	_, unlockB, err := replicaB.lock(t.Context(), "example.com.")
+	if err != nil {
+		t.Fatalf("lock() of a released lease error = %v", err)
+	}
+
+	lease = getTestLease(t, client, "netactuate-webhook.example.com")
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != "replica-b" || ptr.Deref(lease.Spec.LeaseTransitions, 0) != 1 {
+		t.Errorf("lease holder = %v, transitions = %v, want replica-b, 1",
+			ptr.Deref(lease.Spec.HolderIdentity, ""), ptr.Deref(lease.Spec.LeaseTransitions, 0))
+	}
+
+	unlockB()
+
+	lease = getTestLease(t, client, "netactuate-webhook.example.com")
+	if lease.Spec.HolderIdentity != nil {
+		t.Errorf("lease held by %v after release", *lease.Spec.HolderIdentity)
+	}
+}
+
+func TestLeaseLockerSameReplica(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	locker := newTestLeaseLocker(t, client, "replica-a")
+
+	_, unlockFirst, err := locker.lock(t.Context(), "example.com.")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	// another challenge of this replica in the same zone waits its turn
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err = locker.lock(ctx, "example.com.")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock() of a lease held by this replica error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	acquired := make(chan func())
+
+	go func() {
+		_, unlockSecond, err := locker.lock(t.Context(), "example.com.")
+		if err != nil {
+			t.Errorf("lock() after release error = %v", err)
+		}
+
+		acquired <- unlockSecond
+	}()
+
+	unlockFirst()
+
+	unlockSecond := <-acquired
+
+	// releasing the first holder left the lease held for the second
+	lease := getTestLease(t, client, "netactuate-webhook.example.com")
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != "replica-a" {
+		t.Errorf("lease holder = %v, want replica-a", ptr.Deref(lease.Spec.HolderIdentity, ""))
+	}
+
+	if unlockSecond != nil {
+		unlockSecond()
+	}
+
+	lease = getTestLease(t, client, "netactuate-webhook.example.com")
+	if lease.Spec.HolderIdentity != nil {
+		t.Errorf("lease held by %v after release", *lease.Spec.HolderIdentity)
+	}
+}
+
+func TestLeaseLockerExpired(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "netactuate-webhook", Namespace: "cert-manager"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("crashed-replica"),
+			LeaseDurationSeconds: ptr.To(int32(30)),
+			RenewTime:            ptr.To(metav1.NewMicroTime(time.Now().Add(-time.Minute))),
+		},
+	})
+
+	locker := newTestLeaseLocker(t, client, "replica-a")
+	locker.mode = leaseModeGlobal
+
+	_, unlock, err := locker.lock(t.Context(), "example.com.")
+	if err != nil {
+		t.Fatalf("lock() of an expired lease error = %v", err)
+	}
+	defer unlock()
+
+	lease := getTestLease(t, client, "netactuate-webhook")
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != "replica-a" {
+		t.Errorf("lease holder = %v, want replica-a", ptr.Deref(lease.Spec.HolderIdentity, ""))
+	}
+}
+
+func TestLeaseLockerLost(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset()
+	locker := newTestLeaseLocker(t, client, "replica-a")
+
+	ctx, unlock, err := locker.lock(t.Context(), "example.com.")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	// another replica takes the lease, so renewing the stale copy conflicts
+	lease := getTestLease(t, client, "netactuate-webhook.example.com")
+	lease.Spec.HolderIdentity = ptr.To("replica-b")
+	lease.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now()))
+
+	_, err = client.CoordinationV1().Leases("cert-manager").Update(t.Context(), lease, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		update, _ := action.(k8stesting.UpdateAction).GetObject().(*coordinationv1.Lease)
+		if ptr.Deref(update.Spec.HolderIdentity, "") == "replica-a" {
+			return true, nil, apierrors.NewConflict(coordinationv1.Resource("leases"), update.Name, errLeaseAPI)
+		}
+
+		return false, nil, nil
+	})
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("context not canceled after the lease was lost")
+	}
+
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrLeaseLost) {
+		t.Errorf("context canceled by %v, want %v", cause, ErrLeaseLost)
+	}
+
+	unlock()
+
+	// the lease is not released from under the replica that took it
+	lease = getTestLease(t, client, "netactuate-webhook.example.com")
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != "replica-b" {
+		t.Errorf("lease holder = %v, want replica-b", ptr.Deref(lease.Spec.HolderIdentity, ""))
+	}
+}
+
+func TestLeaseLockerFallback(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		fallback string
+		wantErr  bool
+	}{
+		{name: "fail", fallback: leaseFallbackFail, wantErr: true},
+		{name: "proceed", fallback: leaseFallbackProceed, wantErr: false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := fake.NewClientset()
+			client.PrependReactor("get", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errLeaseAPI
+			})
+
+			locker := newTestLeaseLocker(t, client, "replica-a")
+			locker.fallback = testCase.fallback
+
+			_, unlock, err := locker.lock(t.Context(), "example.com.")
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("lock() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+
+			if err == nil {
+				unlock()
+			}
+		})
+	}
+}
+
+func TestLeaseName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		mode string
+		zone string
+		want string
+	}{
+		{name: "zone", mode: leaseModeZone, zone: "Example.COM.", want: "netactuate-webhook.example.com"},
+		{name: "global", mode: leaseModeGlobal, zone: "example.com.", want: "netactuate-webhook"},
+		{
+			name: "invalid name",
+			mode: leaseModeZone,
+			zone: "under_score.example.com.",
+			want: "netactuate-webhook.0acdf6ed090d208c",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			locker := &leaseLocker{mode: testCase.mode}
+			if got := locker.leaseName(testCase.zone); got != testCase.want {
+				t.Errorf("leaseName() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// recordLocks serializes changes to the records of each record name, so a
+// Present and a CleanUp of the same name, for example for a domain and its
+// wildcard, cannot interleave their list and change API calls. The zero
+// value is ready to use.
+type recordLocks struct {
+	locks map[string]*recordLock
+	mu    sync.Mutex
+}
+
+// recordLock is held by whoever holds a slot in sem. refs counts the callers
+// holding or waiting for it, so unused locks can be dropped.
+type recordLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+// lock waits until the record name's lock is acquired or ctx is done, and
+// returns the function that releases it
+func (r *recordLocks) lock(ctx context.Context, name string) (func(), error) {
+	name = normalizeZone(name)
+
+	r.mu.Lock()
+
+	if r.locks == nil {
+		r.locks = map[string]*recordLock{}
+	}
+
+	lock, ok := r.locks[name]
+	if !ok {
+		lock = &recordLock{sem: make(chan struct{}, 1)}
+		r.locks[name] = lock
+	}
+
+	lock.refs++
+	r.mu.Unlock()
+
+	select {
+	case lock.sem <- struct{}{}:
+	case <-ctx.Done():
+		r.release(name, lock)
+
+		return nil, fmt.Errorf("error waiting for lock on %s: %w", name, ctx.Err())
+	}
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			<-lock.sem
+			r.release(name, lock)
+		})
+	}, nil
+}
+
+// release drops a reference to a lock, and the lock once it is unused
+func (r *recordLocks) release(name string, lock *recordLock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(r.locks, name)
+	}
+}
+
+// lockRecord serializes changes to the challenge's record with other calls
+// in this replica and, if Leases are enabled, with other replicas. It returns
+// the context to make the changes with, which is canceled if the Lease is
+// lost, and the function that releases the locks.
+func (c *customDNSProviderSolver) lockRecord(
+	ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest,
+) (context.Context, func(), error) {
+	unlockRecord, err := c.recordLocks.lock(ctx, challengeRequest.ResolvedFQDN)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, unlockLease, err := c.leases.lock(ctx, challengeRequest.ResolvedZone)
+	if err != nil {
+		unlockRecord()
+
+		return nil, nil, err
+	}
+
+	return ctx, func() {
+		unlockLease()
+		unlockRecord()
+	}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentPresentAndCleanUp(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+
+	const hosts = 100
+
+	// the apex and wildcard challenges of a host share a record name. Each
+	// challenge that is not cleaned up leaves its record and owner marker.
+	want := map[string]bool{}
+	errs := make(chan error, hosts*2*3)
+
+	var wg sync.WaitGroup
+
+	for i := range hosts {
+		for j, variant := range []string{"apex", "wildcard"} {
+			challenge := newTestChallenge(fmt.Sprintf("host%d", i), fmt.Sprintf("host%d-%s", i, variant))
+			cleanUp := (i+j)%2 == 0
+
+			if !cleanUp {
+				want[challenge.ResolvedFQDN+" "+challenge.Key] = true
+				want[ownerRecordPrefix+challenge.ResolvedFQDN+" "+keyHash(challenge.Key)] = true
+			}
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				// cert-manager may repeat Present, so each is sent twice at once
+				var presents sync.WaitGroup
+
+				for range 2 {
+					presents.Add(1)
+
+					go func() {
+						defer presents.Done()
+
+						errs <- solver.Present(challenge)
+					}()
+				}
+
+				presents.Wait()
+
+				if cleanUp {
+					errs <- solver.CleanUp(challenge)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Present() or CleanUp() error = %v", err)
+		}
+	}
+
+	got := map[string]bool{}
+
+	for _, record := range api.recordList() {
+		name := record.Name + ". " + record.Content
+
+		// markers are compared by key hash, their content holds a timestamp
+		marker, ok := parseOwnerMarker(record.Content)
+		if ok {
+			name = record.Name + ". " + marker.keyHash
+		}
+		if got[name] {
+			t.Errorf("duplicate record %s", name)
+		}
+
+		got[name] = true
+
+		if !want[name] {
+			t.Errorf("unexpected record %s", name)
+		}
+	}
+
+	for name := range want {
+		if !got[name] {
+			t.Errorf("missing record %s", name)
+		}
+	}
+
+	if len(solver.recordLocks.locks) != 0 {
+		t.Errorf("%d record locks left after all calls returned", len(solver.recordLocks.locks))
+	}
+}
+
+func TestRecordLocksContext(t *testing.T) {
+	t.Parallel()
+
+	var locks recordLocks
+
+	unlock, err := locks.lock(t.Context(), "_acme-challenge.example.com.")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	// names are compared like zones, ignoring case and the trailing dot
+	_, err = locks.lock(ctx, "_ACME-challenge.example.com")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock() of a held lock error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	other, err := locks.lock(t.Context(), "_acme-challenge.example.org")
+	if err != nil {
+		t.Errorf("lock() of another name error = %v", err)
+	} else {
+		other()
+	}
+
+	unlock()
+	unlock()
+
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks left after unlock", len(locks.locks))
+	}
+}
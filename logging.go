@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	"k8s.io/klog/v2"
+)
+
+// setupLogging configures the default logger from LOG_LEVEL (debug, info,
+// warn or error, defaults to info) and LOG_FORMAT (text or json, defaults to
+// text). Messages logged with klog by the webhook server are sent to the same
+// logger.
+func setupLogging() error {
+	var level slog.Level
+
+	levelName := os.Getenv("LOG_LEVEL")
+	if levelName != "" {
+		err := level.UnmarshalText([]byte(levelName))
+		if err != nil {
+			return fmt.Errorf("LOG_LEVEL: %w: %w", ErrInvalidSetting, err)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("LOG_FORMAT: %s, %w", format, ErrInvalidSetting)
+	}
+
+	logger := slog.New(handler)
+
+	slog.SetDefault(logger)
+	klog.SetSlogLogger(logger)
+
+	return nil
+}
+
+// withChallengeLogger returns a copy of ctx carrying a logger whose messages
+// are tagged with the challenge they are about. The netactuate client logs
+// with the same logger.
+func withChallengeLogger(
+	ctx context.Context, action string, challengeRequest *v1alpha1.ChallengeRequest,
+) context.Context {
+	logger := slog.Default().With(
+		"action", action,
+		"uid", challengeRequest.UID,
+		"namespace", challengeRequest.ResourceNamespace,
+		"dnsName", challengeRequest.DNSName,
+		"fqdn", challengeRequest.ResolvedFQDN,
+		"zone", challengeRequest.ResolvedZone,
+	)
+
+	return netactuate.ContextWithLogger(ctx, logger)
+}
+
+// logger returns the logger carried by ctx
+func logger(ctx context.Context) *slog.Logger {
+	return netactuate.LoggerFromContext(ctx)
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log/slog"
+	"net/http"
 	"os"
 	"strings"
 	_ "time/tzdata"
@@ -12,9 +12,10 @@ import (
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
 	cmmetav1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	cmclientset "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
 	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	"go.opentelemetry.io/otel/trace"
 	_ "golang.org/x/crypto/x509roots/fallback"
-	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -27,6 +28,11 @@ func main() {
 		panic("GROUP_NAME must be specified")
 	}
 
+	err := setupLogging()
+	if err != nil {
+		panic(err)
+	}
+
 	// This will register our custom DNS provider with the webhook serving
 	// library, making it available as an API under the provided GroupName.
 	// You can register multiple DNS provider implementations with a single
@@ -48,7 +54,19 @@ type customDNSProviderSolver struct {
 	// 3. uncomment the relevant code in the Initialize method below
 	// 4. ensure your webhook's service account has the required RBAC role
 	//    assigned to it for interacting with the Kubernetes APIs you need.
-	client *kubernetes.Clientset
+	client kubernetes.Interface
+
+	api            *netactuate.Client
+	metrics        *solverMetrics
+	secrets        *secretCache
+	recordLocks    recordLocks
+	leases         *leaseLocker
+	events         *challengeEvents
+	journal        *journal
+	policy         *authorizationPolicy
+	limits         *challengeLimiter
+	tracerProvider trace.TracerProvider
+	settings       webhookSettings
 }
 
 // customDNSProviderConfig is a structure that is used to decode into when
@@ -74,7 +92,52 @@ type customDNSProviderConfig struct {
 	// Email           string `json:"email"`
 	// APIKeySecretRef v1alpha1.SecretKeySelector `json:"apiKeySecretRef"`
 
-	APIKey cmmetav1.SecretKeySelector `json:"apiKey"`
+	// APIVersion is the version of the config schema, see config.go
+	APIVersion string `json:"apiVersion"`
+
+	// APIKey is the default API key, used for zones that have no matching
+	// Credentials or ZoneAPIKeys entry
+	APIKey secretKeySelector `json:"apiKey,omitzero"`
+
+	// Credentials selects API keys by zone, see credentials.go
+	Credentials []zoneCredentials `json:"credentials,omitempty"`
+
+	// ZoneAPIKeys references a Secret whose keys are zone names and whose
+	// values are the API keys for those zones
+	ZoneAPIKeys *secretReference `json:"zoneAPIKeys,omitempty"`
+
+	// Timeout bounds the NetActuate API calls made for a single challenge
+	Timeout metav1.Duration `json:"timeout,omitzero"`
+
+	// TTL of the TXT record in seconds, the NetActuate default is used if unset
+	TTL int `json:"ttl,omitempty"`
+}
+
+// secretKeySelector selects a key of a Secret. Namespace is optional and
+// defaults to the challenge's ResourceNamespace. Other namespaces may only be
+// used by ClusterIssuers, and only if they are allowed by the webhook's
+// SECRET_NAMESPACES setting. SecondaryKey optionally names another key of
+// the same Secret holding an API key to fall back to when NetActuate rejects
+// the primary one, which allows keys to be rotated without downtime.
+type secretKeySelector struct {
+	cmmetav1.SecretKeySelector `json:",inline"`
+
+	Namespace    string `json:"namespace,omitempty"`
+	SecondaryKey string `json:"secondaryKey,omitempty"`
+}
+
+// secretReference references a Secret, with the same namespace rules as
+// secretKeySelector.
+type secretReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// zoneCredentials is the API key to use for a set of zones. A zone also
+// matches its subdomains.
+type zoneCredentials struct {
+	Zones  []string          `json:"zones"`
+	APIKey secretKeySelector `json:"apiKey"`
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -93,54 +156,152 @@ func (c *customDNSProviderSolver) Name() string {
 // cert-manager itself will later perform a self check to ensure that the
 // solver has correctly configured the DNS provider.
 func (c *customDNSProviderSolver) Present(challengeRequest *v1alpha1.ChallengeRequest) error {
+	ctx, span := c.startChallengeSpan("Present", challengeRequest)
+	ctx = withChallengeLogger(ctx, "present", challengeRequest)
+
+	recordID, err := c.present(ctx, challengeRequest)
+	if err == nil {
+		c.limits.presented(challengeRequest)
+	} else {
+		c.limits.presentFailed(challengeRequest)
+	}
+
+	c.metrics.challenge("present", challengeRequest.ResolvedZone, err)
+	c.events.presented(ctx, challengeRequest, recordID, err)
+	endSpan(span, err)
+
+	return err
+}
+
+// present adds the TXT record for a challenge and returns its ID
+func (c *customDNSProviderSolver) present(
+	ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest,
+) (int, error) {
 	var err error
 
 	var cfg customDNSProviderConfig
 
 	cfg, err = loadConfig(challengeRequest.Config)
 	if err != nil {
-		return err
+		logger(ctx).ErrorContext(ctx, "Invalid solver config", "err", err)
+
+		return 0, err
+	}
+
+	err = c.policy.authorize(ctx, challengeRequest)
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Challenge not authorized", "err", err)
+
+		return 0, err
+	}
+
+	err = c.limits.allowPresent(challengeRequest)
+	if err != nil {
+		logger(ctx).WarnContext(ctx, "Challenge rate limited", "err", err)
+
+		return 0, err
 	}
 
-	var apiKey string
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout.Duration)
+	defer cancel()
 
-	apiKey, err = c.loadAPIKey(cfg, challengeRequest)
+	var keys apiKeys
+
+	keys, err = c.loadAPIKey(ctx, cfg, challengeRequest)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	slog.InfoContext(context.Background(), "Presenting TXT record",
-		"key", challengeRequest.Key,
-		"fqdn", challengeRequest.ResolvedFQDN,
-		"zone", challengeRequest.ResolvedZone,
-	)
+	ctx, unlock, err := c.lockRecord(ctx, challengeRequest)
+	if err != nil {
+		return 0, err
+	}
 
-	err = netactuate.DNSRecordPost(
-		apiKey,
-		netactuate.GetDomainFromZone(challengeRequest.ResolvedZone),
-		"TXT",
-		strings.TrimSuffix(challengeRequest.ResolvedFQDN, "."+challengeRequest.ResolvedZone),
-		challengeRequest.Key,
-	)
+	defer unlock()
+
+	// Present may be repeated, so records are only added if they are missing.
+	// The owner marker is added first, so the webhook never creates a record
+	// it would not be allowed to delete.
+	var existing challengeRecords
+
+	existing, err = c.findChallengeRecords(ctx, keys, challengeRequest)
 	if err != nil {
-		slog.ErrorContext(context.Background(), "Error adding TXT record",
-			"key", challengeRequest.Key,
-			"fqdn", challengeRequest.ResolvedFQDN,
-			"zone", challengeRequest.ResolvedZone,
+		return 0, err
+	}
+
+	if existing.record.ID != 0 && existing.marker.ID != 0 {
+		logger(ctx).InfoContext(ctx, "TXT record already present", "key", challengeRequest.Key, "id", existing.record.ID)
+
+		return existing.record.ID, nil
+	}
+
+	entry, err := c.journal.start(ctx, journalActionPresent, challengeRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	recordID, err := c.addChallengeRecords(ctx, keys, cfg, challengeRequest, existing, entry)
+
+	err = c.journal.finish(ctx, entry, err)
+	if err != nil {
+		return 0, err
+	}
+
+	return recordID, nil
+}
+
+// addChallengeRecords adds the owner marker and TXT record of a challenge
+// that are missing from existing, recording its progress in entry, and
+// returns the record's ID. The caller must hold the record's lock.
+func (c *customDNSProviderSolver) addChallengeRecords(
+	ctx context.Context, keys apiKeys, cfg customDNSProviderConfig, challengeRequest *v1alpha1.ChallengeRequest,
+	existing challengeRecords, entry *journalEntry,
+) (int, error) {
+	var err error
+
+	recordName := strings.TrimSuffix(challengeRequest.ResolvedFQDN, "."+challengeRequest.ResolvedZone)
+
+	if existing.marker.ID == 0 {
+		var markerID int
+
+		markerID, err = c.postTXTRecord(ctx, keys, challengeRequest.ResolvedZone,
+			ownerRecordPrefix+recordName, c.newOwnerMarker(challengeRequest.Key).String(), cfg.TTL,
 		)
+		if err != nil {
+			logger(ctx).ErrorContext(ctx, "Error adding owner marker", "err", err)
 
-		return fmt.Errorf("error adding TXT record %s for %s, %s: %w",
+			return 0, fmt.Errorf("error adding owner marker for %s, %s: %w",
+				challengeRequest.ResolvedFQDN, challengeRequest.ResolvedZone, err,
+			)
+		}
+
+		logger(ctx).InfoContext(ctx, "Added owner marker", "id", markerID)
+		c.journal.step(ctx, entry, journalStepMarkerAdded, markerID)
+	}
+
+	if existing.record.ID != 0 {
+		logger(ctx).InfoContext(ctx, "TXT record already present", "key", challengeRequest.Key, "id", existing.record.ID)
+
+		return existing.record.ID, nil
+	}
+
+	logger(ctx).InfoContext(ctx, "Presenting TXT record", "key", challengeRequest.Key)
+
+	var recordID int
+
+	recordID, err = c.postTXTRecord(ctx, keys, challengeRequest.ResolvedZone, recordName, challengeRequest.Key, cfg.TTL)
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Error adding TXT record", "key", challengeRequest.Key, "err", err)
+
+		return 0, fmt.Errorf("error adding TXT record %s for %s, %s: %w",
 			challengeRequest.Key, challengeRequest.ResolvedFQDN, challengeRequest.ResolvedZone, err,
 		)
 	}
 
-	slog.InfoContext(context.Background(), "Added TXT record",
-		"key", challengeRequest.Key,
-		"fqdn", challengeRequest.ResolvedFQDN,
-		"zone", challengeRequest.ResolvedZone,
-	)
+	logger(ctx).InfoContext(ctx, "Added TXT record", "key", challengeRequest.Key, "id", recordID)
+	c.journal.step(ctx, entry, journalStepRecordAdded, recordID)
 
-	return nil
+	return recordID, nil
 }
 
 // CleanUp should delete the relevant TXT record from the DNS provider console.
@@ -150,6 +311,25 @@ func (c *customDNSProviderSolver) Present(challengeRequest *v1alpha1.ChallengeRe
 // This is in order to facilitate multiple DNS validations for the same domain
 // concurrently.
 func (c *customDNSProviderSolver) CleanUp(challengeRequest *v1alpha1.ChallengeRequest) error {
+	ctx, span := c.startChallengeSpan("CleanUp", challengeRequest)
+	ctx = withChallengeLogger(ctx, "cleanup", challengeRequest)
+
+	recordID, err := c.cleanUp(ctx, challengeRequest)
+	if err == nil || errors.Is(err, ErrTXTRecordNotFound) {
+		c.limits.cleanedUp(challengeRequest)
+	}
+
+	c.metrics.challenge("cleanup", challengeRequest.ResolvedZone, err)
+	c.events.cleanedUp(ctx, challengeRequest, recordID, err)
+	endSpan(span, err)
+
+	return err
+}
+
+// cleanUp deletes the TXT record of a challenge and returns its ID
+func (c *customDNSProviderSolver) cleanUp(
+	ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest,
+) (int, error) {
 	var err error
 
 	var cfg customDNSProviderConfig
@@ -157,76 +337,172 @@ func (c *customDNSProviderSolver) CleanUp(challengeRequest *v1alpha1.ChallengeRe
 	// add code that deletes a record from the DNS provider's console
 	cfg, err = loadConfig(challengeRequest.Config)
 	if err != nil {
-		return err
+		logger(ctx).ErrorContext(ctx, "Invalid solver config", "err", err)
+
+		return 0, err
+	}
+
+	err = c.policy.authorize(ctx, challengeRequest)
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Challenge not authorized", "err", err)
+
+		return 0, err
 	}
 
-	var apiKey string
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout.Duration)
+	defer cancel()
 
-	apiKey, err = c.loadAPIKey(cfg, challengeRequest)
+	var keys apiKeys
+
+	keys, err = c.loadAPIKey(ctx, cfg, challengeRequest)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// 1. fetch the TXT record id
-	var dnsRecordList []netactuate.DNSRecord
+	ctx, unlock, err := c.lockRecord(ctx, challengeRequest)
+	if err != nil {
+		return 0, err
+	}
 
-	dnsRecordList, err = netactuate.DNSRecordsGet(
-		apiKey,
-		netactuate.GetDomainFromZone(challengeRequest.ResolvedZone),
-	)
+	defer unlock()
+
+	entry, err := c.journal.start(ctx, journalActionCleanUp, challengeRequest)
 	if err != nil {
-		slog.ErrorContext(context.Background(), "Error listing records",
-			"fqdn", challengeRequest.ResolvedFQDN,
-			"zone", challengeRequest.ResolvedZone,
-			"err", err,
-		)
+		return 0, err
+	}
 
-		return fmt.Errorf(
-			"error listing record for %s, %s: %w",
-			challengeRequest.ResolvedFQDN, challengeRequest.ResolvedZone, err,
-		)
+	recordID, err := c.deleteChallengeRecords(ctx, keys, challengeRequest, entry)
+
+	err = c.journal.finish(ctx, entry, err)
+	if err != nil {
+		return 0, err
+	}
+
+	return recordID, nil
+}
+
+// deleteChallengeRecords deletes the TXT record and owner marker of a
+// challenge, recording its progress in entry, and returns the record's ID.
+// The caller must hold the record's lock.
+func (c *customDNSProviderSolver) deleteChallengeRecords(
+	ctx context.Context, keys apiKeys, challengeRequest *v1alpha1.ChallengeRequest, entry *journalEntry,
+) (int, error) {
+	var err error
+
+	// 1. fetch the TXT record and owner marker ids
+	var found challengeRecords
+
+	found, err = c.findChallengeRecords(ctx, keys, challengeRequest)
+	if err != nil {
+		return 0, err
 	}
 
-	var targetRecord netactuate.DNSRecord
+	switch {
+	case found.record.ID == 0 && found.marker.ID == 0:
+		logger(ctx).ErrorContext(ctx, "No TXT record found", "key", challengeRequest.Key)
 
-	for _, r := range dnsRecordList {
-		rfqdn := netactuate.GetDomainFromZone(challengeRequest.ResolvedFQDN)
-		if r.Name == rfqdn &&
-			r.RecordType == "TXT" && r.Content == challengeRequest.Key {
-			targetRecord = r
+		return 0, fmt.Errorf("no TXT record found for %s, %w", challengeRequest.ResolvedFQDN, ErrTXTRecordNotFound)
+	case c.adopts(found):
+		logger(ctx).WarnContext(ctx, "Adopting TXT record without owner marker", "id", found.record.ID)
+	case found.marker.ID == 0:
+		logger(ctx).ErrorContext(ctx, "Refusing to delete TXT record without owner marker", "id", found.record.ID)
 
-			break
+		return 0, fmt.Errorf("TXT record %d for %s, %w", found.record.ID, challengeRequest.ResolvedFQDN, ErrRecordNotOwned)
+	}
+
+	// 2. delete the TXT record, which is already gone if a previous CleanUp
+	// failed to delete the marker
+	if found.record.ID != 0 {
+		logger(ctx).InfoContext(ctx, "Found TXT record", "id", found.record.ID)
+
+		err = c.deleteTXTRecord(ctx, keys, found.record)
+		if err != nil {
+			logger(ctx).ErrorContext(ctx, "Error deleting TXT record", "id", found.record.ID, "err", err)
+
+			return 0, fmt.Errorf("error deleting TXT record: %w", err)
 		}
+
+		logger(ctx).InfoContext(ctx, "Deleted TXT record", "id", found.record.ID)
+		c.journal.step(ctx, entry, journalStepRecordDeleted, found.record.ID)
 	}
 
-	if targetRecord.ID == 0 {
-		slog.ErrorContext(context.Background(), "No TXT record found",
-			"fqdn", challengeRequest.ResolvedFQDN,
-		)
+	// 3. delete the owner marker, an adopted record has none
+	if found.marker.ID == 0 {
+		return found.record.ID, nil
+	}
 
-		return fmt.Errorf("no TXT record found for %s, %w", challengeRequest.ResolvedFQDN, ErrTXTRecordNotFound)
+	err = c.deleteTXTRecord(ctx, keys, found.marker)
+	if err != nil {
+		logger(ctx).ErrorContext(ctx, "Error deleting owner marker", "id", found.marker.ID, "err", err)
+
+		return 0, fmt.Errorf("error deleting owner marker: %w", err)
 	}
 
-	slog.InfoContext(context.Background(), "Found TXT record",
-		"id", targetRecord.ID,
-		"fqdn", challengeRequest.ResolvedFQDN,
-		"zone", challengeRequest.ResolvedZone,
-	)
+	logger(ctx).InfoContext(ctx, "Deleted owner marker", "id", found.marker.ID)
+
+	return found.record.ID, nil
+}
 
-	// 2. delete the TXT record
-	err = netactuate.DNSRecordDelete(apiKey, targetRecord.ID)
+// findChallengeRecords returns the challenge's TXT record and owner marker
+func (c *customDNSProviderSolver) findChallengeRecords(
+	ctx context.Context, keys apiKeys, challengeRequest *v1alpha1.ChallengeRequest,
+) (challengeRecords, error) {
+	var err error
+
+	var dnsRecordList []netactuate.DNSRecord
+
+	err = c.withAPIKey(ctx, keys, func(apiKey string) error {
+		dnsRecordList, err = c.api.DNSRecordsGet(
+			ctx,
+			apiKey,
+			netactuate.GetDomainFromZone(challengeRequest.ResolvedZone),
+		)
+
+		return err
+	})
 	if err != nil {
-		slog.ErrorContext(context.Background(), "Error deleting TXT record",
-			"id", targetRecord.ID,
-			"fqdn", challengeRequest.ResolvedFQDN,
-			"zone", challengeRequest.ResolvedZone,
-			"err", err.Error(),
+		logger(ctx).ErrorContext(ctx, "Error listing records", "err", err)
+
+		return challengeRecords{}, fmt.Errorf(
+			"error listing record for %s, %s: %w",
+			challengeRequest.ResolvedFQDN, challengeRequest.ResolvedZone, err,
 		)
+	}
 
-		return fmt.Errorf("error deleting TXT record: %w", err)
+	return c.matchChallengeRecords(dnsRecordList, challengeRequest), nil
+}
+
+// postTXTRecord adds a TXT record named name, relative to zone, and returns
+// its ID
+func (c *customDNSProviderSolver) postTXTRecord(
+	ctx context.Context, keys apiKeys, zone string, name string, content string, ttl int,
+) (int, error) {
+	var err error
+
+	var recordID int
+
+	err = c.withAPIKey(ctx, keys, func(apiKey string) error {
+		recordID, err = c.api.DNSRecordPost(ctx, apiKey, netactuate.GetDomainFromZone(zone), "TXT", name, content, ttl)
+
+		return err
+	})
+
+	return recordID, err
+}
+
+// deleteTXTRecord deletes a TXT record found in a record list, if the
+// deletion policy allows it
+func (c *customDNSProviderSolver) deleteTXTRecord(
+	ctx context.Context, keys apiKeys, record netactuate.DNSRecord,
+) error {
+	err := c.checkDeletion(ctx, keys, record)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return c.withAPIKey(ctx, keys, func(apiKey string) error {
+		return c.api.DNSRecordDelete(ctx, apiKey, record.ID)
+	})
 }
 
 // Initialize will be called when the webhook first starts.
@@ -238,7 +514,7 @@ func (c *customDNSProviderSolver) CleanUp(challengeRequest *v1alpha1.ChallengeRe
 // provider accounts.
 // The stopCh can be used to handle early termination of the webhook, in cases
 // where a SIGTERM or similar signal is sent to the webhook process.
-func (c *customDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, _ <-chan struct{}) error {
+func (c *customDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
 	cl, err := kubernetes.NewForConfig(kubeClientConfig)
 	if err != nil {
 		return fmt.Errorf("error getting client config: %w", err)
@@ -246,41 +522,117 @@ func (c *customDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, _ <-
 
 	c.client = cl
 
-	return nil
-}
+	c.settings, err = loadSettings()
+	if err != nil {
+		return err
+	}
+
+	if c.settings.policyFile != "" {
+		c.policy, err = loadPolicy(c.settings.policyFile, c.client)
+		if err != nil {
+			return err
+		}
+	}
 
-// loadAPIKey loads netactuate API key
-func (c *customDNSProviderSolver) loadAPIKey(cfg customDNSProviderConfig, challengeRequest *v1alpha1.ChallengeRequest) (string, error) { //nolint:lll
-	backGroundCtx := context.Background()
+	if c.settings.ownerID == "" {
+		c.settings.ownerID, err = clusterID(context.Background(), c.client)
+		if err != nil {
+			return err
+		}
+	}
 
-	secret, err := c.client.CoreV1().Secrets(challengeRequest.ResourceNamespace).Get(
-		backGroundCtx, cfg.APIKey.Name, metav1.GetOptions{})
+	c.secrets, err = newSecretCache(
+		c.client, c.settings.secretCacheNamespaces, c.settings.secretCacheLabelSelector, stopCh,
+	)
 	if err != nil {
-		return "", fmt.Errorf("error getting api key: %w", err)
+		return fmt.Errorf("error starting secret cache: %w", err)
 	}
 
-	keyBytes, ok := secret.Data[cfg.APIKey.Key]
-	if !ok {
-		return "", fmt.Errorf("secret key not found, namespace: %s name: %s, key: %s, got: %#v, %w",
-			challengeRequest.ResourceNamespace, cfg.APIKey.Name, cfg.APIKey.Key, secret, ErrAPIKeyDecode)
+	challenges, err := cmclientset.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return fmt.Errorf("error getting cert-manager client config: %w", err)
 	}
 
-	return string(keyBytes), nil
-}
+	if c.settings.challengeEvents {
+		c.events, err = newChallengeEvents(c.client, challenges, stopCh)
+		if err != nil {
+			return fmt.Errorf("error starting challenge events: %w", err)
+		}
+	}
 
-// loadConfig is a small helper function that decodes JSON configuration into
-// the typed config struct.
-func loadConfig(cfgJSON *extapi.JSON) (customDNSProviderConfig, error) {
-	cfg := customDNSProviderConfig{}
-	// handle the 'base case' where no configuration has been provided
-	if cfgJSON == nil {
-		return cfg, nil
+	c.tracerProvider, err = setupTracing(stopCh)
+	if err != nil {
+		return err
 	}
 
-	err := json.Unmarshal(cfgJSON.Raw, &cfg)
+	registry := newMetricsRegistry()
+
+	c.metrics, err = newSolverMetrics(registry)
 	if err != nil {
-		return cfg, fmt.Errorf("error decoding solver config: %w", err)
+		return err
 	}
 
-	return cfg, nil
+	apiMetrics, err := netactuate.NewMetrics(registry)
+	if err != nil {
+		return fmt.Errorf("error creating netactuate client: %w", err)
+	}
+
+	httpClient, err := netactuate.NewHTTPClient(c.settings.apiTransport)
+	if err != nil {
+		return fmt.Errorf("error creating netactuate client: %w", err)
+	}
+
+	c.api = netactuate.NewClient(
+		netactuate.WithHTTPClient(httpClient),
+		netactuate.WithMiddleware(netactuate.RequestID(), netactuate.DebugLogging()),
+		netactuate.WithMetrics(apiMetrics),
+		netactuate.WithTracerProvider(c.tracerProvider),
+		netactuate.WithCircuitBreaker(c.settings.circuitBreakerThreshold, c.settings.circuitBreakerOpenDuration),
+	)
+
+	c.limits = newChallengeLimiter(c.settings, c.metrics)
+
+	if c.settings.leaseMode != "" {
+		c.leases = &leaseLocker{
+			client:        c.client,
+			metrics:       c.metrics,
+			mode:          c.settings.leaseMode,
+			namespace:     c.settings.leaseNamespace,
+			identity:      c.settings.leaseIdentity,
+			fallback:      c.settings.leaseFallback,
+			duration:      c.settings.leaseDuration,
+			retryInterval: c.settings.leaseRetryInterval,
+		}
+	}
+
+	if c.settings.journal {
+		c.journal = &journal{
+			client:    c.client,
+			namespace: c.settings.leaseNamespace,
+			identity:  c.settings.leaseIdentity,
+		}
+		c.startJournalReplay(stopCh)
+	}
+
+	if len(c.settings.gcZones) > 0 {
+		err = c.startGarbageCollector(challenges, stopCh)
+		if err != nil {
+			return err
+		}
+	}
+
+	var readiness http.Handler
+
+	if c.settings.readinessSecretName != "" {
+		readiness = c.startReadinessCheck(stopCh)
+	}
+
+	if c.settings.metricsBindAddress != "" {
+		err = serveMetrics(c.settings.metricsBindAddress, registry, readiness, stopCh)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
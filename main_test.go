@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
 	acmetest "github.com/cert-manager/cert-manager/test/acme"
 )
 
@@ -31,3 +32,79 @@ func TestRunsSuite(t *testing.T) { //nolint:paralleltest,wsl
 	fixture.RunBasic(t)
 	fixture.RunExtended(t)
 }
+
+func TestSecretNamespace(t *testing.T) {
+	t.Parallel()
+
+	solver := &customDNSProviderSolver{
+		settings: webhookSettings{
+			clusterResourceNamespace: "cert-manager",
+			secretNamespaces:         []string{"dns-credentials"},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		resourceNamespace string
+		selectorNamespace string
+		want              string
+		wantErr           bool
+	}{
+		{
+			name:              "default namespace",
+			resourceNamespace: "team-a",
+			want:              "team-a",
+		},
+		{
+			name:              "same namespace",
+			resourceNamespace: "team-a",
+			selectorNamespace: "team-a",
+			want:              "team-a",
+		},
+		{
+			name:              "issuer reading another namespace",
+			resourceNamespace: "team-a",
+			selectorNamespace: "dns-credentials",
+			wantErr:           true,
+		},
+		{
+			name:              "cluster issuer reading allowed namespace",
+			resourceNamespace: "cert-manager",
+			selectorNamespace: "dns-credentials",
+			want:              "dns-credentials",
+		},
+		{
+			// challenges do not carry the issuer's kind, so an Issuer in the
+			// cluster resource namespace is treated as a ClusterIssuer
+			name:              "issuer in the cluster resource namespace",
+			resourceNamespace: "cert-manager",
+			selectorNamespace: "dns-credentials",
+			want:              "dns-credentials",
+		},
+		{
+			name:              "cluster issuer reading other namespace",
+			resourceNamespace: "cert-manager",
+			selectorNamespace: "team-a",
+			wantErr:           true,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			challengeRequest := &v1alpha1.ChallengeRequest{ResourceNamespace: testCase.resourceNamespace}
+
+			got, err := solver.secretNamespace(testCase.selectorNamespace, challengeRequest)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("secretNamespace() error = %v, wantErr %v", err, testCase.wantErr)
+
+				return
+			}
+
+			if got != testCase.want {
+				t.Errorf("secretNamespace() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const metricsShutdownTimeout = 5 * time.Second
+
+// solverMetrics are the Prometheus metrics reported by the solver. A nil
+// *solverMetrics reports nothing.
+type solverMetrics struct {
+	challenges           *prometheus.CounterVec
+	secretLookupFailures *prometheus.CounterVec
+	apiKeysUsed          *prometheus.CounterVec
+	leaseContentions     *prometheus.CounterVec
+	leaseWaits           *prometheus.HistogramVec
+	gcRuns               *prometheus.CounterVec
+	gcRecordsDeleted     *prometheus.CounterVec
+	gcLastSuccess        prometheus.Gauge
+	deletionsRefused     *prometheus.CounterVec
+	rateLimitedCalls     *prometheus.CounterVec
+	apiReadiness         *prometheus.GaugeVec
+}
+
+// newSolverMetrics creates the solver metrics and registers them with
+// registerer
+func newSolverMetrics(registerer prometheus.Registerer) (*solverMetrics, error) {
+	metrics := &solverMetrics{
+		challenges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_challenges_total",
+			Help: "Number of Present and CleanUp calls by action, zone and result, success or the class of error.",
+		}, []string{"action", "zone", "result"}),
+		secretLookupFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_secret_lookup_failures_total",
+			Help: "Number of failed API key secret lookups by reason.",
+		}, []string{"reason"}),
+		apiKeysUsed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_api_key_used_total",
+			Help: "Number of NetActuate API calls that succeeded by the API key used, primary or secondary.",
+		}, []string{"key"}),
+		leaseContentions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_lease_contentions_total",
+			Help: "Number of times a Lease was held by another replica when it was needed, by lease mode.",
+		}, []string{"mode"}),
+		leaseWaits: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "netactuate_webhook_lease_wait_seconds",
+			Help:    "Time spent acquiring a Lease by result, acquired, timeout, error or fallback.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"result"}),
+		gcRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_gc_runs_total",
+			Help: "Number of garbage collector runs by result, success or error.",
+		}, []string{"result"}),
+		gcRecordsDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_gc_records_deleted_total",
+			Help: "Number of stale challenge records and owner markers deleted by the garbage collector, " +
+				"or that would have been deleted in dry run mode.",
+		}, []string{"dry_run"}),
+		gcLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "netactuate_webhook_gc_last_success_timestamp_seconds",
+			Help: "Time of the last successful garbage collector run.",
+		}),
+		deletionsRefused: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_deletions_refused_total",
+			Help: "Number of record deletions refused by the deletion policy by reason, type, changed or name.",
+		}, []string{"reason"}),
+		rateLimitedCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_webhook_rate_limited_total",
+			Help: "Number of Present calls rejected by limit, namespace, zone or outstanding, and namespace.",
+		}, []string{"limit", "namespace"}),
+		apiReadiness: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "netactuate_webhook_api_readiness",
+			Help: "State of the NetActuate API readiness check, 1 for the current state and 0 for the others.",
+		}, []string{"state"}),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		metrics.challenges, metrics.secretLookupFailures, metrics.apiKeysUsed, metrics.leaseContentions, metrics.leaseWaits,
+		metrics.gcRuns, metrics.gcRecordsDeleted, metrics.gcLastSuccess, metrics.deletionsRefused,
+		metrics.rateLimitedCalls, metrics.apiReadiness,
+	} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, fmt.Errorf("error registering solver metrics: %w", err)
+		}
+	}
+
+	return metrics, nil
+}
+
+func (m *solverMetrics) challenge(action string, zone string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.challenges.WithLabelValues(action, normalizeZone(zone), errorClass(err)).Inc()
+}
+
+func (m *solverMetrics) secretLookupFailed(err error) {
+	if m == nil {
+		return
+	}
+
+	reason := "other"
+
+	switch {
+	case errors.Is(err, ErrSecretNamespaceNotAllowed):
+		reason = "namespace_not_allowed"
+	case errors.Is(err, ErrAPIKeyDecode):
+		reason = "key_not_found"
+	case errors.Is(err, ErrNoCredentials):
+		reason = "no_credentials"
+	case apierrors.IsNotFound(err):
+		reason = "secret_not_found"
+	case apierrors.IsForbidden(err):
+		reason = "forbidden"
+	}
+
+	m.secretLookupFailures.WithLabelValues(reason).Inc()
+}
+
+func (m *solverMetrics) apiKeyUsed(key string) {
+	if m == nil {
+		return
+	}
+
+	m.apiKeysUsed.WithLabelValues(key).Inc()
+}
+
+func (m *solverMetrics) leaseContended(mode string) {
+	if m == nil {
+		return
+	}
+
+	m.leaseContentions.WithLabelValues(mode).Inc()
+}
+
+func (m *solverMetrics) leaseWait(result string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.leaseWaits.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+func (m *solverMetrics) gcRun(err error) {
+	if m == nil {
+		return
+	}
+
+	if err != nil {
+		m.gcRuns.WithLabelValues("error").Inc()
+
+		return
+	}
+
+	m.gcRuns.WithLabelValues("success").Inc()
+	m.gcLastSuccess.SetToCurrentTime()
+}
+
+func (m *solverMetrics) gcRecordDeleted(dryRun bool) {
+	if m == nil {
+		return
+	}
+
+	m.gcRecordsDeleted.WithLabelValues(strconv.FormatBool(dryRun)).Inc()
+}
+
+func (m *solverMetrics) deletionRefused(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.deletionsRefused.WithLabelValues(reason).Inc()
+}
+
+func (m *solverMetrics) rateLimited(limit string, namespace string) {
+	if m == nil {
+		return
+	}
+
+	m.rateLimitedCalls.WithLabelValues(limit, namespace).Inc()
+}
+
+func (m *solverMetrics) readinessState(state string) {
+	if m == nil {
+		return
+	}
+
+	for _, known := range readinessStates {
+		value := 0.0
+		if known == state {
+			value = 1
+		}
+
+		m.apiReadiness.WithLabelValues(known).Set(value)
+	}
+}
+
+// errorClass returns a low cardinality name for the kind of error
+func errorClass(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrInvalidConfig):
+		return "config"
+	case errors.Is(err, ErrSecretLookup):
+		return "credentials"
+	case errors.Is(err, netactuate.ErrIPNotAllowed):
+		return "ip_not_allowed"
+	case errors.Is(err, netactuate.ErrServiceUnavailable):
+		return "circuit_open"
+	case errors.Is(err, netactuate.ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, netactuate.ErrDomainNotFound):
+		return "zone_not_found"
+	case errors.Is(err, ErrTXTRecordNotFound):
+		return "record_not_found"
+	case errors.Is(err, ErrRecordNotOwned):
+		return "not_owned"
+	case errors.Is(err, ErrDeletionRefused):
+		return "deletion_refused"
+	case errors.Is(err, ErrNotAuthorized):
+		return "not_authorized"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, netactuate.ErrHTTPNotOK), errors.Is(err, netactuate.ErrUnknown):
+		return "api"
+	default:
+		return "other"
+	}
+}
+
+// newMetricsRegistry returns a registry holding the Go runtime and process
+// metrics
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return registry
+}
+
+// serveMetrics serves the registry's metrics on /metrics at address until
+// stopCh is closed
+func serveMetrics(address string, registry *prometheus.Registry, readiness http.Handler, stopCh <-chan struct{}) error {
+	listener, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	if readiness != nil {
+		mux.Handle("/readyz", readiness)
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.ErrorContext(context.Background(), "Error serving metrics", "err", err)
+		}
+	}()
+
+	go func() {
+		<-stopCh
+
+		ctx, cancel := context.WithTimeout(context.Background(), metricsShutdownTimeout)
+		defer cancel()
+
+		_ = server.Shutdown(ctx)
+	}()
+
+	slog.InfoContext(context.Background(), "Serving metrics", "address", listener.Addr().String())
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package netactuate
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+
+	defaultBreakerThreshold    = 5
+	defaultBreakerOpenDuration = 30 * time.Second
+)
+
+// circuitBreaker stops requests to an API that keeps failing. It opens after
+// threshold consecutive failed requests, network errors and 5xx responses,
+// and then fails requests fast until openDuration has passed. A single probe
+// request is then let through, half open, which closes the breaker if it
+// succeeds and opens it again if it fails. A nil *circuitBreaker never opens.
+type circuitBreaker struct {
+	openedAt     time.Time
+	now          func() time.Time
+	metrics      *Metrics
+	state        string
+	threshold    int
+	failures     int
+	openDuration time.Duration
+	probing      bool
+	mu           sync.Mutex
+}
+
+// breakerOutcome is the result of a request for the breaker
+type breakerOutcome int
+
+const (
+	// outcomeSuccess is a request the API answered, whatever its status
+	outcomeSuccess breakerOutcome = iota
+	// outcomeFailure is a network error or 5xx response
+	outcomeFailure
+	// outcomeIgnored is a request canceled by its caller
+	outcomeIgnored
+)
+
+func newCircuitBreaker(threshold int, openDuration time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		now:          time.Now,
+		state:        CircuitClosed,
+		threshold:    threshold,
+		openDuration: openDuration,
+	}
+}
+
+// allow reports whether a request may be sent
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.openDuration {
+			return false
+		}
+
+		b.setState(CircuitHalfOpen)
+		b.probing = true
+
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+
+		return true
+	default:
+		return true
+	}
+}
+
+// record records the outcome of a request allowed by allow
+func (b *circuitBreaker) record(outcome breakerOutcome) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitHalfOpen {
+		b.probing = false
+	}
+
+	switch outcome {
+	case outcomeSuccess:
+		b.failures = 0
+		b.setState(CircuitClosed)
+	case outcomeFailure:
+		b.failures++
+		if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+			b.openedAt = b.now()
+			b.setState(CircuitOpen)
+		}
+	case outcomeIgnored:
+	}
+}
+
+// currentState returns the breaker's state
+func (b *circuitBreaker) currentState() string {
+	if b == nil {
+		return CircuitClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *circuitBreaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+	b.metrics.circuitState(state)
+}
@@ -0,0 +1,130 @@
+package netactuate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	// each step either asks the breaker to allow a request, checking the
+	// answer, or records an outcome, and then checks the state
+	type step struct {
+		advance   time.Duration
+		outcome   breakerOutcome
+		record    bool
+		wantAllow bool
+		wantState string
+	}
+
+	allow := func(want bool, state string) step {
+		return step{wantAllow: want, wantState: state}
+	}
+
+	record := func(outcome breakerOutcome, state string) step {
+		return step{outcome: outcome, record: true, wantState: state}
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after consecutive failures",
+			steps: []step{
+				record(outcomeFailure, CircuitClosed),
+				record(outcomeFailure, CircuitOpen),
+				allow(false, CircuitOpen),
+			},
+		},
+		{
+			name: "success resets the failures",
+			steps: []step{
+				record(outcomeFailure, CircuitClosed),
+				record(outcomeSuccess, CircuitClosed),
+				record(outcomeFailure, CircuitClosed),
+				allow(true, CircuitClosed),
+			},
+		},
+		{
+			name: "canceled requests are ignored",
+			steps: []step{
+				record(outcomeFailure, CircuitClosed),
+				record(outcomeIgnored, CircuitClosed),
+				record(outcomeFailure, CircuitOpen),
+			},
+		},
+		{
+			name: "probe closes the breaker",
+			steps: []step{
+				record(outcomeFailure, CircuitClosed),
+				record(outcomeFailure, CircuitOpen),
+				{advance: time.Minute, wantAllow: true, wantState: CircuitHalfOpen},
+				allow(false, CircuitHalfOpen),
+				record(outcomeSuccess, CircuitClosed),
+				allow(true, CircuitClosed),
+			},
+		},
+		{
+			name: "failed probe opens the breaker again",
+			steps: []step{
+				record(outcomeFailure, CircuitClosed),
+				record(outcomeFailure, CircuitOpen),
+				{advance: time.Minute, wantAllow: true, wantState: CircuitHalfOpen},
+				record(outcomeFailure, CircuitOpen),
+				{advance: time.Second, wantAllow: false, wantState: CircuitOpen},
+				{advance: time.Minute, wantAllow: true, wantState: CircuitHalfOpen},
+			},
+		},
+		{
+			name: "canceled probe lets another probe through",
+			steps: []step{
+				record(outcomeFailure, CircuitClosed),
+				record(outcomeFailure, CircuitOpen),
+				{advance: time.Minute, wantAllow: true, wantState: CircuitHalfOpen},
+				record(outcomeIgnored, CircuitHalfOpen),
+				allow(true, CircuitHalfOpen),
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Now()
+			breaker := newCircuitBreaker(2, time.Minute)
+			breaker.now = func() time.Time { return now }
+
+			for i, step := range testCase.steps {
+				now = now.Add(step.advance)
+
+				if step.record {
+					breaker.record(step.outcome)
+				} else if got := breaker.allow(); got != step.wantAllow {
+					t.Errorf("step %d: allow() = %v, want %v", i, got, step.wantAllow)
+				}
+
+				if got := breaker.currentState(); got != step.wantState {
+					t.Errorf("step %d: state = %s, want %s", i, got, step.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestNewCircuitBreakerDisabled(t *testing.T) {
+	t.Parallel()
+
+	breaker := newCircuitBreaker(0, time.Minute)
+	if breaker != nil {
+		t.Fatalf("newCircuitBreaker(0) = %v, want nil", breaker)
+	}
+
+	breaker.record(outcomeFailure)
+
+	if !breaker.allow() || breaker.currentState() != CircuitClosed {
+		t.Errorf("nil breaker does not allow requests")
+	}
+}
@@ -0,0 +1,370 @@
+// Package cassette records HTTP interactions with an API to a cassette file
+// and replays them, so tests run against the API's real responses without
+// calling it. A Recorder is an http.RoundTripper: in ModeRecord it sends
+// requests to the API and saves each request and response, with the API key
+// and other secrets scrubbed, and in ModeReplay it answers requests from the
+// saved interactions.
+package cassette
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Mode is whether a Recorder records or replays its cassette
+type Mode int
+
+const (
+	// ModeReplay answers requests from the cassette, without calling the API
+	ModeReplay Mode = iota
+	// ModeRecord sends requests to the API and saves them to the cassette
+	ModeRecord
+)
+
+// Redacted replaces the API key and other secrets in recorded interactions
+const Redacted = "REDACTED"
+
+var (
+	ErrNoInteraction   = errors.New("no matching interaction in cassette")
+	ErrInvalidCassette = errors.New("invalid cassette")
+)
+
+// keptHeaders are the response headers saved to cassettes, others vary
+// between calls or may carry credentials
+var keptHeaders = []string{"Content-Type"}
+
+// Cassette is the file format of a cassette, the interactions in the order
+// they were recorded
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request. The API key is scrubbed from the query.
+type Request struct {
+	Query  url.Values `json:"query,omitempty"`
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+}
+
+// Response is a recorded response
+type Response struct {
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body"`
+	Status  int         `json:"status"`
+}
+
+// Matcher reports whether a request matches a recorded request
+type Matcher func(req *http.Request, recorded Request) bool
+
+// MatchMethod matches requests with the recorded method
+func MatchMethod() Matcher {
+	return func(req *http.Request, recorded Request) bool {
+		return req.Method == recorded.Method
+	}
+}
+
+// MatchPath matches requests with the recorded path
+func MatchPath() Matcher {
+	return func(req *http.Request, recorded Request) bool {
+		return req.URL.Path == recorded.Path
+	}
+}
+
+// MatchQuery matches requests with the recorded query, apart from the
+// ignored parameters
+func MatchQuery(ignored ...string) Matcher {
+	return func(req *http.Request, recorded Request) bool {
+		query := req.URL.Query()
+
+		for _, name := range ignored {
+			query.Del(name)
+			delete(recorded.Query, name)
+		}
+
+		return len(query) == len(recorded.Query) && equalQuery(query, recorded.Query)
+	}
+}
+
+// MatchAll matches requests matched by every matcher
+func MatchAll(matchers ...Matcher) Matcher {
+	return func(req *http.Request, recorded Request) bool {
+		for _, matcher := range matchers {
+			if !matcher(req, recorded) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// DefaultMatcher matches the method, path and query of requests, ignoring
+// the API key
+func DefaultMatcher() Matcher {
+	return MatchAll(MatchMethod(), MatchPath(), MatchQuery("key"))
+}
+
+// Recorder records or replays the interactions of a cassette. Each recorded
+// interaction is replayed once, in order, so repeated requests get the
+// responses they got when recorded.
+type Recorder struct {
+	next         http.RoundTripper
+	matcher      Matcher
+	path         string
+	cassette     Cassette
+	replacements []string
+	used         []bool
+	mode         Mode
+	mu           sync.Mutex
+}
+
+// Option configures a Recorder
+type Option func(*Recorder)
+
+// WithMatcher sets how requests are matched to recorded requests, defaults
+// to DefaultMatcher
+func WithMatcher(matcher Matcher) Option {
+	return func(r *Recorder) {
+		r.matcher = matcher
+	}
+}
+
+// WithTransport sets the transport requests are sent with when recording,
+// defaults to http.DefaultTransport
+func WithTransport(next http.RoundTripper) Option {
+	return func(r *Recorder) {
+		r.next = next
+	}
+}
+
+// WithReplacement replaces secret, such as an API key or a real domain, with
+// placeholder in the paths, queries and bodies of recorded interactions.
+// Tests replaying the cassette send the placeholder.
+func WithReplacement(secret string, placeholder string) Option {
+	return func(r *Recorder) {
+		if secret != "" {
+			r.replacements = append(r.replacements, secret, placeholder)
+		}
+	}
+}
+
+// New returns a Recorder for the cassette at path. In ModeReplay the cassette
+// is read from path, in ModeRecord it is written to path by Save.
+func New(path string, mode Mode, options ...Option) (*Recorder, error) {
+	recorder := &Recorder{
+		next:    http.DefaultTransport,
+		matcher: DefaultMatcher(),
+		path:    path,
+		mode:    mode,
+	}
+
+	for _, option := range options {
+		option(recorder)
+	}
+
+	if mode == ModeRecord {
+		return recorder, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cassette: %w", err)
+	}
+
+	err = yaml.UnmarshalStrict(raw, &recorder.cassette)
+	if err != nil {
+		return nil, fmt.Errorf("cassette %s: %w: %w", path, ErrInvalidCassette, err)
+	}
+
+	recorder.used = make([]bool, len(recorder.cassette.Interactions))
+
+	return recorder, nil
+}
+
+// RoundTrip records or replays a request
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == ModeRecord {
+		return r.record(req)
+	}
+
+	return r.replay(req)
+}
+
+// replay answers req with the first unused interaction that matches it
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !r.matcher(req, cloneRequest(interaction.Request)) {
+			continue
+		}
+
+		r.used[i] = true
+
+		return interaction.Response.httpResponse(req), nil
+	}
+
+	return nil, fmt.Errorf("cassette %s: %s %s: %w", r.path, req.Method, req.URL.Path, ErrNoInteraction)
+}
+
+// record sends req and saves it and its response
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // recorded as sent
+	}
+
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	query := req.URL.Query()
+	if query.Has("key") {
+		query.Set("key", Redacted)
+	}
+
+	for name, values := range query {
+		for i, value := range values {
+			values[i] = r.scrub(value)
+		}
+
+		query[name] = values
+	}
+
+	headers := http.Header{}
+
+	for _, name := range keptHeaders {
+		if value := res.Header.Get(name); value != "" {
+			headers.Set(name, value)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: Request{
+			Query:  query,
+			Method: req.Method,
+			Path:   r.scrub(req.URL.Path),
+		},
+		Response: Response{
+			Headers: headers,
+			Body:    r.scrub(string(body)),
+			Status:  res.StatusCode,
+		},
+	})
+
+	return res, nil
+}
+
+// scrub replaces the secrets in value with their placeholders
+func (r *Recorder) scrub(value string) string {
+	return strings.NewReplacer(r.replacements...).Replace(value)
+}
+
+// Save writes the recorded interactions to the cassette, it does nothing
+// when replaying
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	raw, err := yaml.Marshal(r.cassette)
+	if err != nil {
+		return fmt.Errorf("error encoding cassette: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(r.path), 0o750)
+	if err != nil {
+		return fmt.Errorf("error creating cassette directory: %w", err)
+	}
+
+	err = os.WriteFile(r.path, raw, 0o600)
+	if err != nil {
+		return fmt.Errorf("error writing cassette: %w", err)
+	}
+
+	return nil
+}
+
+// Unused returns the interactions that were not replayed, as "METHOD path",
+// so tests can check every recorded request was made
+func (r *Recorder) Unused() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []string
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.mode == ModeReplay && !r.used[i] {
+			unused = append(unused, interaction.Request.Method+" "+interaction.Request.Path)
+		}
+	}
+
+	return unused
+}
+
+// httpResponse returns the recorded response to req
+func (r Response) httpResponse(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(r.Status) + " " + http.StatusText(r.Status),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Headers.Clone(),
+		Body:          io.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// cloneRequest returns a copy of recorded that a Matcher may change
+func cloneRequest(recorded Request) Request {
+	recorded.Query = url.Values(http.Header(recorded.Query).Clone())
+	if recorded.Query == nil {
+		recorded.Query = url.Values{}
+	}
+
+	return recorded
+}
+
+// equalQuery reports whether two queries with the same number of parameters
+// have the same values
+func equalQuery(query url.Values, recorded url.Values) bool {
+	for name, values := range query {
+		if !slices.Equal(values, recorded[name]) {
+			return false
+		}
+	}
+
+	return true
+}
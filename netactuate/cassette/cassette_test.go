@@ -0,0 +1,181 @@
+package cassette
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, client *http.Client, reqURL string) (string, error) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, reqURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err //nolint:wrapcheck // checked by the test
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body), nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret-session")
+		_, _ = w.Write([]byte(`{"zone": "` + strings.TrimPrefix(r.URL.Path, "/zones/") + `", "call": ` +
+			strconv.Itoa(calls) + `}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassettes", "zones.yaml")
+
+	recorder, err := New(path, ModeRecord, WithReplacement("real.example", "example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: recorder}
+
+	for range 2 {
+		_, err = get(t, client, server.URL+"/zones/real.example?key=secret-key&type=NATIVE")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = recorder.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"secret-key", "secret-session", "real.example"} {
+		if strings.Contains(string(saved), secret) {
+			t.Errorf("cassette holds %s:\n%s", secret, saved)
+		}
+	}
+
+	replayer, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = &http.Client{Transport: replayer}
+
+	// repeated requests are answered in the order they were recorded, and
+	// the key is not matched
+	for _, want := range []string{`{"zone": "example.com", "call": 1}`, `{"zone": "example.com", "call": 2}`} {
+		body, err := get(t, client, "http://api.invalid/zones/example.com?type=NATIVE&key=other-key")
+		if err != nil || body != want {
+			t.Errorf("replayed body = %s, %v, want %s", body, err, want)
+		}
+	}
+
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Errorf("Unused() = %v, want none", unused)
+	}
+
+	_, err = get(t, client, "http://api.invalid/zones/example.com?type=NATIVE")
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("request after the cassette ran out error = %v, want %v", err, ErrNoInteraction)
+	}
+
+	if calls != 2 {
+		t.Errorf("server called %d times, want 2", calls)
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	t.Parallel()
+
+	recorded := Request{
+		Method: http.MethodGet,
+		Path:   "/api/dns/zones",
+		Query:  map[string][]string{"key": {Redacted}, "type": {"NATIVE"}},
+	}
+
+	tests := []struct {
+		matcher Matcher
+		name    string
+		method  string
+		url     string
+		want    bool
+	}{
+		{
+			name:    "default",
+			matcher: DefaultMatcher(),
+			method:  http.MethodGet,
+			url:     "/api/dns/zones?key=k&type=NATIVE",
+			want:    true,
+		},
+		{name: "method", matcher: DefaultMatcher(), method: http.MethodPost, url: "/api/dns/zones?key=k&type=NATIVE"},
+		{name: "path", matcher: DefaultMatcher(), method: http.MethodGet, url: "/api/dns/records?key=k&type=NATIVE"},
+		{name: "query value", matcher: DefaultMatcher(), method: http.MethodGet, url: "/api/dns/zones?key=k&type=ALL"},
+		{name: "extra query", matcher: DefaultMatcher(), method: http.MethodGet, url: "/api/dns/zones?key=k&type=NATIVE&a=b"},
+		{
+			name:    "ignored query",
+			matcher: MatchAll(MatchMethod(), MatchPath(), MatchQuery("key", "type")),
+			method:  http.MethodGet,
+			url:     "/api/dns/zones?type=ALL",
+			want:    true,
+		},
+		{name: "path only", matcher: MatchPath(), method: http.MethodDelete, url: "/api/dns/zones", want: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(testCase.method, testCase.url, nil)
+
+			if got := testCase.matcher(req, cloneRequest(recorded)); got != testCase.want {
+				t.Errorf("matcher() = %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidCassette(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "invalid.yaml")
+
+	err := os.WriteFile(path, []byte("interactions:\n- request:\n    verb: GET\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(path, ModeReplay)
+	if !errors.Is(err, ErrInvalidCassette) {
+		t.Errorf("New() error = %v, want %v", err, ErrInvalidCassette)
+	}
+}
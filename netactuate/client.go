@@ -0,0 +1,324 @@
+package netactuate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	DefaultBaseURL = "https://vapi2.netactuate.com"
+
+	tracerName = "github.com/swills/cert-manager-webhook-netactuate/netactuate"
+
+	defaultZoneCacheTTL = 5 * time.Minute
+	defaultMaxRetries   = 2
+	defaultRetryWait    = time.Second
+)
+
+// Client makes calls to the NetActuate API. Zone IDs are cached, GET
+// requests that fail with a network error or a 5xx status are retried, calls
+// fail fast with ErrServiceUnavailable while the API keeps failing and, if
+// metrics are configured, every call is measured.
+type Client struct {
+	httpClient *http.Client
+	breaker    *circuitBreaker
+	middleware []Middleware
+	logger     *slog.Logger
+	metrics    *Metrics
+	tracer     trace.Tracer
+	zones      *zoneCache
+	baseURL    string
+	zoneType   string
+	maxRetries int
+	retryWait  time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithBaseURL sets the URL of the NetActuate API, defaults to DefaultBaseURL
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient sets the http.Client used for API calls, defaults to a
+// client returned by NewHTTPClient with the default TransportConfig
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithMetrics reports the client's API calls, retries and zone cache lookups
+// to metrics
+func WithMetrics(metrics *Metrics) Option {
+	return func(c *Client) {
+		c.metrics = metrics
+	}
+}
+
+// WithTracerProvider creates a span for every API request with tracerProvider,
+// defaults to the global tracer provider. The trace context is propagated to
+// NetActuate with the global propagator.
+func WithTracerProvider(tracerProvider trace.TracerProvider) Option {
+	return func(c *Client) {
+		c.tracer = tracerProvider.Tracer(tracerName)
+	}
+}
+
+// WithZoneCacheTTL sets how long zone IDs are cached, 0 disables caching
+func WithZoneCacheTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.zones.ttl = ttl
+	}
+}
+
+// WithZoneType sets the type of the zones DNSZoneGet lists and GetZoneID
+// finds, defaults to ZoneTypeNative. An empty type lists zones of every type.
+func WithZoneType(zoneType string) Option {
+	return func(c *Client) {
+		c.zoneType = zoneType
+	}
+}
+
+// WithRetries sets how many times a failed GET request is retried, and the
+// wait before the first retry, which grows linearly with each retry
+func WithRetries(maxRetries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryWait = wait
+	}
+}
+
+// WithCircuitBreaker opens the circuit breaker after threshold consecutive
+// failed requests, failing requests with ErrServiceUnavailable for
+// openDuration before a probe request is let through. A threshold of 0
+// disables the breaker. Defaults to 5 failures and 30 seconds.
+func WithCircuitBreaker(threshold int, openDuration time.Duration) Option {
+	return func(c *Client) {
+		c.breaker = newCircuitBreaker(threshold, openDuration)
+	}
+}
+
+// NewClient creates a NetActuate API client
+func NewClient(options ...Option) *Client {
+	client := &Client{
+		httpClient: newHTTPClient(TransportConfig{}, nil),
+		breaker:    newCircuitBreaker(defaultBreakerThreshold, defaultBreakerOpenDuration),
+		tracer:     otel.GetTracerProvider().Tracer(tracerName),
+		zones:      newZoneCache(defaultZoneCacheTTL),
+		baseURL:    DefaultBaseURL,
+		zoneType:   ZoneTypeNative,
+		maxRetries: defaultMaxRetries,
+		retryWait:  defaultRetryWait,
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	client.httpClient = chain(client.httpClient, client.middleware)
+
+	if client.breaker != nil {
+		client.breaker.metrics = client.metrics
+		client.metrics.circuitState(client.breaker.currentState())
+	}
+
+	return client
+}
+
+// CircuitState returns the state of the circuit breaker, CircuitClosed,
+// CircuitOpen or CircuitHalfOpen
+func (c *Client) CircuitState() string {
+	return c.breaker.currentState()
+}
+
+// do makes an API request and returns the response body. endpoint names
+// the API call in metrics. GET requests are retried on network errors and
+// 5xx responses.
+func (c *Client) do(
+	ctx context.Context, endpoint string, method string, path string, apiKey string, query url.Values,
+) ([]byte, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+
+	query.Set("key", apiKey)
+
+	reqURL := c.baseURL + path + "?" + query.Encode()
+
+	attempts := 1
+	if method == http.MethodGet {
+		attempts += c.maxRetries
+	}
+
+	var body []byte
+
+	var err error
+
+	for attempt := range attempts {
+		if attempt > 0 {
+			c.metrics.retried(endpoint)
+			c.log(ctx).WarnContext(ctx, "Retrying NetActuate API request",
+				"endpoint", endpoint,
+				"attempt", attempt,
+				"err", err,
+			)
+
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("error waiting to retry: %w, last error: %w", ctx.Err(), err)
+			case <-time.After(c.retryWait * time.Duration(attempt)):
+			}
+		}
+
+		if !c.breaker.allow() {
+			c.metrics.circuitRejected(endpoint)
+
+			if err != nil {
+				return nil, fmt.Errorf("netactuate api circuit breaker open, last error: %w, %w",
+					err, ErrServiceUnavailable)
+			}
+
+			return nil, fmt.Errorf("netactuate api circuit breaker open, %w", ErrServiceUnavailable)
+		}
+
+		var retry bool
+
+		body, retry, err = c.doOnce(ctx, endpoint, method, reqURL)
+		c.breaker.record(breakerOutcomeOf(ctx, err, retry))
+
+		if err == nil || !retry {
+			break
+		}
+	}
+
+	return body, err
+}
+
+// breakerOutcomeOf returns the circuit breaker outcome of a request. Failures
+// that would be retried count against the API, requests canceled by the
+// caller are ignored and any other response shows the API is up.
+func breakerOutcomeOf(ctx context.Context, err error, retry bool) breakerOutcome {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case retry:
+		return outcomeFailure
+	case ctx.Err() != nil:
+		return outcomeIgnored
+	default:
+		return outcomeSuccess
+	}
+}
+
+// doOnce makes a single API request, reporting whether a failed request may
+// be retried
+func (c *Client) doOnce(ctx context.Context, endpoint string, method string, reqURL string) ([]byte, bool, error) {
+	ctx, span := c.tracer.Start(ctx, "netactuate "+endpoint, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	// middleware logs with the client's logger
+	ctx = ContextWithLogger(ctx, c.log(ctx))
+
+	body, retry, err := c.send(ctx, endpoint, method, reqURL)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return body, retry, err
+}
+
+// send sends a single API request within the request's span
+func (c *Client) send(ctx context.Context, endpoint string, method string, reqURL string) ([]byte, bool, error) {
+	span := trace.SpanFromContext(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("error creating http request: %w", err)
+	}
+
+	// the URL is not recorded as it holds the API key
+	span.SetAttributes(
+		attribute.String("netactuate.endpoint", endpoint),
+		attribute.String("http.request.method", method),
+		attribute.String("url.path", req.URL.Path),
+	)
+
+	req.Header.Add("accept", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		redactURLError(err)
+		c.metrics.observeRequest(endpoint, "error", time.Since(start))
+		c.log(ctx).DebugContext(ctx, "NetActuate API request failed",
+			"endpoint", endpoint,
+			"duration", time.Since(start),
+			"err", err,
+		)
+
+		return nil, ctx.Err() == nil, fmt.Errorf("error making http request: %w", err)
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	body, err := io.ReadAll(res.Body)
+
+	c.metrics.observeRequest(endpoint, strconv.Itoa(res.StatusCode), time.Since(start))
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	c.log(ctx).DebugContext(ctx, "NetActuate API request",
+		"endpoint", endpoint,
+		"status", res.StatusCode,
+		"duration", time.Since(start),
+	)
+
+	if err != nil {
+		return nil, ctx.Err() == nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	err = checkStatus(res, body)
+	if err != nil {
+		return nil, res.StatusCode >= http.StatusInternalServerError, err
+	}
+
+	return body, false, nil
+}
+
+// redactURLError removes the API key from the URL held by a transport
+// error, so it is not leaked into logs, spans or Events
+func redactURLError(err error) {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return
+	}
+
+	parsed, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil {
+		urlErr.URL = ""
+
+		return
+	}
+
+	urlErr.URL = redactURL(parsed)
+}
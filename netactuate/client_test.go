@@ -0,0 +1,287 @@
+package netactuate
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClientZoneCacheAndRetries(t *testing.T) {
+	t.Parallel()
+
+	var zoneRequests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/dns/zones":
+			// the first request fails, and should be retried
+			if zoneRequests.Add(1) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"result": "success", "code": 200, "data": [{"name": "example.com", "id": 42}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+
+	metrics, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(WithBaseURL(server.URL), WithMetrics(metrics), WithRetries(2, 0))
+
+	for range 3 {
+		zoneID, err := client.GetZoneID(t.Context(), "EXAMPLE.com.", "test-key")
+		if err != nil || zoneID != 42 {
+			t.Fatalf("GetZoneID() = %v, %v, want 42", zoneID, err)
+		}
+	}
+
+	if got := zoneRequests.Load(); got != 2 {
+		t.Errorf("zone list requested %d times, want 2", got)
+	}
+
+	if got := testutil.ToFloat64(metrics.retries.WithLabelValues("dns_zones")); got != 1 {
+		t.Errorf("retries = %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(metrics.zoneCacheLookups.WithLabelValues("hit")); got != 2 {
+		t.Errorf("zone cache hits = %v, want 2", got)
+	}
+
+	_, err = client.GetZoneID(t.Context(), "example.com", "other-key")
+	if err == nil {
+		t.Errorf("GetZoneID() with a rejected key returned no error")
+	}
+}
+
+func TestClientLogsWithContextLogger(t *testing.T) {
+	t.Parallel()
+
+	var failed atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !failed.Swap(true) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"result": "success", "code": 200, "data": []}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx := ContextWithLogger(t.Context(), logger.With("uid", "challenge-uid"))
+
+	client := NewClient(WithBaseURL(server.URL), WithRetries(1, 0), WithLogger(slog.New(slog.DiscardHandler)))
+
+	_, err := client.DNSZoneGet(ctx, "test-key")
+	if err != nil {
+		t.Fatalf("DNSZoneGet() error = %v", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, "uid=challenge-uid") {
+			t.Errorf("log line without the context logger's attributes: %s", line)
+		}
+	}
+
+	if !strings.Contains(buf.String(), "Retrying NetActuate API request") {
+		t.Errorf("retry not logged: %s", buf.String())
+	}
+
+	if strings.Contains(buf.String(), "test-key") {
+		t.Errorf("api key logged: %s", buf.String())
+	}
+}
+
+func TestClientRedactsAPIKeyFromErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	client := NewClient(WithBaseURL(server.URL), WithRetries(0, 0))
+
+	_, err := client.DNSZoneGet(t.Context(), "secret-test-key")
+	if err == nil {
+		t.Fatal("DNSZoneGet() against a closed server returned no error")
+	}
+
+	if strings.Contains(err.Error(), "secret-test-key") {
+		t.Errorf("api key in error: %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "key=REDACTED") {
+		t.Errorf("redacted url missing from error: %v", err)
+	}
+}
+
+func TestClientRejections(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		body         string
+		wantSourceIP string
+		wantErr      error
+		status       int
+	}{
+		{
+			name:    "unauthorized status",
+			status:  http.StatusUnauthorized,
+			wantErr: ErrUnauthorized,
+		},
+		{
+			name:         "acl status",
+			status:       http.StatusForbidden,
+			body:         `{"result": "failure", "message": "Access denied for IP 192.0.2.10."}`,
+			wantSourceIP: "192.0.2.10",
+			wantErr:      ErrIPNotAllowed,
+		},
+		{
+			name:         "acl not whitelisted",
+			status:       http.StatusUnauthorized,
+			body:         `{"result": "failure", "message": "Request from 2001:db8::10 not whitelisted"}`,
+			wantSourceIP: "2001:db8::10",
+			wantErr:      ErrIPNotAllowed,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			wantErr: ErrHTTPNotOK,
+		},
+		{
+			name:    "unauthorized code",
+			status:  http.StatusOK,
+			body:    `{"result": "failure", "code": 401, "message": "Invalid API key"}`,
+			wantErr: ErrUnauthorized,
+		},
+		{
+			name:    "acl code without address",
+			status:  http.StatusOK,
+			body:    `{"result": "failure", "code": 403, "message": "Your IP address is not in the API ACL"}`,
+			wantErr: ErrIPNotAllowed,
+		},
+		{
+			name:    "acl message with server error",
+			status:  http.StatusInternalServerError,
+			body:    `{"result": "failure", "message": "Request from 192.0.2.10 not whitelisted"}`,
+			wantErr: ErrHTTPNotOK,
+		},
+		{
+			name:    "unauthorized mentioning ip",
+			status:  http.StatusForbidden,
+			body:    `{"result": "failure", "message": "API key is not valid for this IP range"}`,
+			wantErr: ErrUnauthorized,
+		},
+		{
+			name:    "bad request mentioning acl",
+			status:  http.StatusOK,
+			body:    `{"result": "failure", "code": 400, "message": "Invalid ACL entry"}`,
+			wantErr: ErrHTTPNotOK,
+		},
+		{
+			name:   "success mentioning ip",
+			status: http.StatusOK,
+			body:   `{"result": "success", "message": "Record for IP 192.0.2.10", "data": []}`,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(testCase.status)
+				_, _ = w.Write([]byte(testCase.body))
+			}))
+			defer server.Close()
+
+			client := NewClient(WithBaseURL(server.URL), WithRetries(0, 0))
+
+			_, err := client.DNSZoneGet(t.Context(), "test-key")
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("DNSZoneGet() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			var ipErr *IPNotAllowedError
+			if errors.As(err, &ipErr) && ipErr.SourceIP != testCase.wantSourceIP {
+				t.Errorf("source ip = %q, want %q", ipErr.SourceIP, testCase.wantSourceIP)
+			}
+		})
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(WithBaseURL(server.URL), WithMetrics(metrics), WithRetries(0, 0),
+		WithCircuitBreaker(2, time.Hour))
+
+	for range 2 {
+		_, err = client.DNSZoneGet(t.Context(), "test-key")
+		if !errors.Is(err, ErrHTTPNotOK) {
+			t.Fatalf("DNSZoneGet() error = %v, want %v", err, ErrHTTPNotOK)
+		}
+	}
+
+	_, err = client.DNSZoneGet(t.Context(), "test-key")
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("DNSZoneGet() with the circuit open error = %v, want %v", err, ErrServiceUnavailable)
+	}
+
+	if got := requests.Load(); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+
+	if got := client.CircuitState(); got != CircuitOpen {
+		t.Errorf("CircuitState() = %s, want %s", got, CircuitOpen)
+	}
+
+	if got := testutil.ToFloat64(metrics.circuitStates.WithLabelValues(CircuitOpen)); got != 1 {
+		t.Errorf("open circuit state = %v, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(metrics.circuitRejects.WithLabelValues("dns_zones")); got != 1 {
+		t.Errorf("circuit rejections = %v, want 1", got)
+	}
+}
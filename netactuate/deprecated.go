@@ -0,0 +1,48 @@
+package netactuate
+
+import (
+	"context"
+)
+
+// defaultClient serves the package-level functions, which predate Client
+var defaultClient = NewClient()
+
+// GetZoneID returns the zone ID for a domain name, if it exists
+//
+// Deprecated: Use Client.GetZoneID, which takes a context.
+func GetZoneID(domainName string, apiKey string) (int, error) {
+	return defaultClient.GetZoneID(context.Background(), domainName, apiKey)
+}
+
+// DNSZoneGet returns a list of all DNS Zones for an account
+//
+// Deprecated: Use Client.DNSZoneGet, which takes a context.
+func DNSZoneGet(apiKey string) (*ZoneList, error) {
+	return defaultClient.DNSZoneGet(context.Background(), apiKey)
+}
+
+// DNSRecordPost Adds a new DNS record to a Zone
+//
+// Deprecated: Use Client.DNSRecordPost, which takes a context and returns the
+// record's ID.
+func DNSRecordPost(apiKey string, domainName string, recordType string, recordName string, recordContent string) error {
+	_, err := defaultClient.DNSRecordPost(
+		context.Background(), apiKey, domainName, recordType, recordName, recordContent, 0,
+	)
+
+	return err
+}
+
+// DNSRecordsGet gets a list of DNS records for the given domain
+//
+// Deprecated: Use Client.DNSRecordsGet, which takes a context.
+func DNSRecordsGet(apiKey string, domainName string) ([]DNSRecord, error) {
+	return defaultClient.DNSRecordsGet(context.Background(), apiKey, domainName)
+}
+
+// DNSRecordDelete deletes a DNS record
+//
+// Deprecated: Use Client.DNSRecordDelete, which takes a context.
+func DNSRecordDelete(apiKey string, recordID int) error {
+	return defaultClient.DNSRecordDelete(context.Background(), apiKey, recordID)
+}
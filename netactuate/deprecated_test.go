@@ -0,0 +1,26 @@
+package netactuate
+
+import (
+	"errors"
+	"testing"
+)
+
+//nolint:paralleltest // replaces the default client
+func TestDeprecatedGetZoneID(t *testing.T) {
+	client, apiKey, domain := newCassetteClient(t, "get_zone_id")
+
+	previous := defaultClient
+	defaultClient = client
+
+	t.Cleanup(func() { defaultClient = previous })
+
+	got, err := GetZoneID(domain, apiKey)
+	if err != nil || got != cassetteZoneID {
+		t.Errorf("GetZoneID() = %v, %v, want %v", got, err, cassetteZoneID)
+	}
+
+	_, err = GetZoneID("missing."+domain, apiKey)
+	if !errors.Is(err, ErrDomainNotFound) {
+		t.Errorf("GetZoneID() of a missing zone error = %v, want %v", err, ErrDomainNotFound)
+	}
+}
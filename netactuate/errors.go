@@ -1,9 +1,35 @@
 package netactuate
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrHTTPNotOK      = errors.New("bad http status code")
 	ErrDomainNotFound = errors.New("domain not found")
 	ErrUnknown        = errors.New("unknown error")
+	ErrUnauthorized   = errors.New("api key rejected")
+	ErrIPNotAllowed   = errors.New("source address not allowed by api acl")
+
+	ErrServiceUnavailable = errors.New("netactuate api unavailable")
+	ErrInvalidCABundle    = errors.New("no certificates found in ca bundle")
+	ErrMalformedResponse  = errors.New("malformed api response")
 )
+
+// IPNotAllowedError is returned when NetActuate rejects a request because
+// its source address is not in the account's API ACL. SourceIP is the address
+// NetActuate reported the request came from, empty if it did not report one.
+type IPNotAllowedError struct {
+	Status   string
+	Message  string
+	SourceIP string
+}
+
+func (e *IPNotAllowedError) Error() string {
+	return fmt.Sprintf("error response from netactuate api: %s %s, %s", e.Status, e.Message, ErrIPNotAllowed)
+}
+
+func (e *IPNotAllowedError) Unwrap() error {
+	return ErrIPNotAllowed
+}
@@ -0,0 +1,47 @@
+package netactuate
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying logger. Clients log with
+// the logger of the request's context, so their messages share the caller's
+// attributes.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger carried by ctx, or the default logger
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+
+	return logger
+}
+
+// WithLogger sets the logger used for requests whose context carries none,
+// defaults to the default logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// log returns the logger for a request
+func (c *Client) log(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if ok {
+		return logger
+	}
+
+	if c.logger != nil {
+		return c.logger
+	}
+
+	return slog.Default()
+}
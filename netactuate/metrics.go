@@ -0,0 +1,108 @@
+package netactuate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the Prometheus metrics reported by a Client. A nil *Metrics
+// reports nothing.
+type Metrics struct {
+	requestDuration  *prometheus.HistogramVec
+	retries          *prometheus.CounterVec
+	zoneCacheLookups *prometheus.CounterVec
+	circuitStates    *prometheus.GaugeVec
+	circuitRejects   *prometheus.CounterVec
+}
+
+// NewMetrics creates the client metrics and registers them with registerer
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	metrics := &Metrics{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "netactuate_api_request_duration_seconds",
+			Help:    "Duration of NetActuate API requests by endpoint and HTTP status, error if no response was received.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"endpoint", "status"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_api_retries_total",
+			Help: "Number of NetActuate API requests retried by endpoint.",
+		}, []string{"endpoint"}),
+		zoneCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_zone_cache_lookups_total",
+			Help: "Number of zone ID lookups by result, hit or miss.",
+		}, []string{"result"}),
+		circuitStates: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "netactuate_api_circuit_state",
+			Help: "State of the NetActuate API circuit breaker, 1 for the current state and 0 for the others.",
+		}, []string{"state"}),
+		circuitRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "netactuate_api_circuit_rejections_total",
+			Help: "Number of NetActuate API requests failed fast by the open circuit breaker by endpoint.",
+		}, []string{"endpoint"}),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		metrics.requestDuration, metrics.retries, metrics.zoneCacheLookups, metrics.circuitStates, metrics.circuitRejects,
+	} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, fmt.Errorf("error registering netactuate metrics: %w", err)
+		}
+	}
+
+	return metrics, nil
+}
+
+func (m *Metrics) observeRequest(endpoint string, status string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.requestDuration.WithLabelValues(endpoint, status).Observe(duration.Seconds())
+}
+
+func (m *Metrics) retried(endpoint string) {
+	if m == nil {
+		return
+	}
+
+	m.retries.WithLabelValues(endpoint).Inc()
+}
+
+func (m *Metrics) zoneCacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	m.zoneCacheLookups.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) circuitState(state string) {
+	if m == nil {
+		return
+	}
+
+	for _, known := range []string{CircuitClosed, CircuitOpen, CircuitHalfOpen} {
+		value := 0.0
+		if known == state {
+			value = 1
+		}
+
+		m.circuitStates.WithLabelValues(known).Set(value)
+	}
+}
+
+func (m *Metrics) circuitRejected(endpoint string) {
+	if m == nil {
+		return
+	}
+
+	m.circuitRejects.WithLabelValues(endpoint).Inc()
+}
@@ -0,0 +1,222 @@
+package netactuate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// RequestIDHeader is the header RequestID sets
+	RequestIDHeader = "X-Request-Id"
+
+	// maxLoggedBody limits the response body logged by DebugLogging
+	maxLoggedBody = 4096
+
+	requestIDBytes = 16
+)
+
+// Version is the version of the webhook, stamped into the User-Agent of API
+// requests. It is set at build time with
+// -ldflags "-X github.com/swills/cert-manager-webhook-netactuate/netactuate.Version=...".
+var Version = "dev"
+
+// redactedHeaders are the headers DebugLogging leaves out of its messages
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Middleware wraps the http.RoundTripper API requests are sent with, to
+// inspect or change requests and responses. A middleware that changes a
+// request must change a clone of it, as http.RoundTripper requires.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an http.RoundTripper implemented by a function, for
+// writing middleware
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req)
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// WithMiddleware adds middleware to the chain every API request is sent
+// through. The first middleware added sees a request first and its response
+// last. The chain ends with the transport of the client's http.Client, after
+// the built in UserAgent middleware, which stamps DefaultUserAgent on requests
+// that have no User-Agent yet.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// DefaultUserAgent returns the User-Agent of API requests,
+// cert-manager-webhook-netactuate with the build's Version
+func DefaultUserAgent() string {
+	return "cert-manager-webhook-netactuate/" + Version
+}
+
+// chain returns a copy of httpClient sending requests through middleware
+func chain(httpClient *http.Client, middleware []Middleware) *http.Client {
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	transport = UserAgent(DefaultUserAgent())(transport)
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		transport = middleware[i](transport)
+	}
+
+	chained := *httpClient
+	chained.Transport = transport
+
+	return &chained
+}
+
+// UserAgent sets the User-Agent of requests that have none to userAgent
+func UserAgent(userAgent string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("User-Agent") == "" {
+				req = req.Clone(req.Context())
+				req.Header.Set("User-Agent", userAgent)
+			}
+
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// RequestID sets the RequestIDHeader of requests that have none to a random
+// ID, so a request can be found in NetActuate's and a proxy's logs. Retries
+// are new requests with new IDs.
+func RequestID() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(RequestIDHeader) == "" {
+				id := make([]byte, requestIDBytes)
+				_, _ = rand.Read(id)
+
+				req = req.Clone(req.Context())
+				req.Header.Set(RequestIDHeader, hex.EncodeToString(id))
+			}
+
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// OnRequest calls hook with every request before it is sent. hook must not
+// change the request.
+func OnRequest(hook func(req *http.Request)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			hook(req)
+
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// OnResponse calls hook with every request and its response, or the error
+// sending it. hook must not read the response body, which the client reads.
+func OnResponse(hook func(req *http.Request, res *http.Response, err error)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			res, err := next.RoundTrip(req)
+			hook(req, res, err)
+
+			return res, err //nolint:wrapcheck // returned as sent
+		})
+	}
+}
+
+// DebugLogging logs every request and response, with its headers and the
+// start of the response body, at debug level with the logger of the
+// request's context. The API key and credential headers are redacted.
+func DebugLogging() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+
+			logger := LoggerFromContext(ctx)
+			if !logger.Enabled(ctx, slog.LevelDebug) {
+				return next.RoundTrip(req)
+			}
+
+			logger.DebugContext(ctx, "NetActuate API request sent",
+				"method", req.Method,
+				"url", redactURL(req.URL),
+				"headers", redactHeaders(req.Header),
+			)
+
+			start := time.Now()
+
+			res, err := next.RoundTrip(req)
+			if err != nil {
+				logger.DebugContext(ctx, "NetActuate API request failed", "duration", time.Since(start), "err", err)
+
+				return nil, err //nolint:wrapcheck // returned as sent
+			}
+
+			body, readErr := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			res.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err: readErr}))
+
+			logger.DebugContext(ctx, "NetActuate API response received",
+				"status", res.StatusCode,
+				"duration", time.Since(start),
+				"headers", redactHeaders(res.Header),
+				"body", string(body[:min(len(body), maxLoggedBody)]),
+			)
+
+			return res, nil
+		})
+	}
+}
+
+// errReader returns err, or io.EOF if err is nil, so a response body read by
+// DebugLogging fails for the client as it failed for the middleware
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return 0, io.EOF
+}
+
+// redactHeaders returns a copy of header without credentials
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+
+	for _, name := range redactedHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "REDACTED")
+		}
+	}
+
+	return redacted
+}
+
+// redactURL returns reqURL with its API key redacted
+func redactURL(reqURL *url.URL) string {
+	redacted := *reqURL
+
+	query := redacted.Query()
+	if query.Has("key") {
+		query.Set("key", "REDACTED")
+		redacted.RawQuery = query.Encode()
+	}
+
+	return redacted.String()
+}
@@ -0,0 +1,119 @@
+package netactuate
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var received http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+
+		w.Header().Set("Set-Cookie", "session=secret-session")
+		_, _ = w.Write([]byte(`{"result": "success", "code": 200, "data": [{"name": "example.com", "id": 42}]}`))
+	}))
+	defer server.Close()
+
+	var calls []string
+
+	// mark records its call and sets a header that DebugLogging must redact
+	mark := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name)
+
+				req = req.Clone(req.Context())
+				req.Header.Set("Authorization", "Bearer secret-token")
+
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	var (
+		requestID string
+		status    int
+	)
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := NewClient(
+		WithBaseURL(server.URL),
+		WithLogger(logger),
+		WithMiddleware(mark("first"), RequestID(), DebugLogging()),
+		WithMiddleware(
+			mark("second"),
+			OnRequest(func(req *http.Request) {
+				requestID = req.Header.Get(RequestIDHeader)
+			}),
+			OnResponse(func(_ *http.Request, res *http.Response, _ error) {
+				status = res.StatusCode
+			}),
+		),
+	)
+
+	zones, err := client.DNSZoneGet(t.Context(), "test-key")
+	if err != nil || len(zones.Data) != 1 {
+		t.Fatalf("DNSZoneGet() = %+v, %v, want the zone read through DebugLogging", zones, err)
+	}
+
+	if strings.Join(calls, ",") != "first,second" {
+		t.Errorf("middleware called in order %v, want first,second", calls)
+	}
+
+	if requestID == "" || received.Get(RequestIDHeader) != requestID {
+		t.Errorf("request id = %q, sent %q", requestID, received.Get(RequestIDHeader))
+	}
+
+	if got := received.Get("User-Agent"); got != DefaultUserAgent() {
+		t.Errorf("User-Agent = %q, want %q", got, DefaultUserAgent())
+	}
+
+	if status != http.StatusOK {
+		t.Errorf("OnResponse status = %d, want %d", status, http.StatusOK)
+	}
+
+	logged := buf.String()
+	if !strings.Contains(logged, "NetActuate API response received") || !strings.Contains(logged, requestID) {
+		t.Errorf("request not logged: %s", logged)
+	}
+
+	for _, secret := range []string{"test-key", "secret-token", "secret-session"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("%s logged: %s", secret, logged)
+		}
+	}
+}
+
+func TestUserAgentKeepsCallerUserAgent(t *testing.T) {
+	t.Parallel()
+
+	var userAgent string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		_, _ = w.Write([]byte(`{"result": "success", "code": 200, "data": []}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(WithBaseURL(server.URL), WithMiddleware(UserAgent("auditor/1.0")))
+
+	_, err := client.DNSZoneGet(t.Context(), "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if userAgent != "auditor/1.0" {
+		t.Errorf("User-Agent = %q, want auditor/1.0", userAgent)
+	}
+}
@@ -1,28 +1,55 @@
 package netactuate
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+var (
+	// ipRejectionPattern matches the messages of 401 and 403 responses
+	// rejecting a request because its source address is not in the account's
+	// API ACL
+	ipRejectionPattern = regexp.MustCompile(`(?i)\bnot allowed from\b|\baccess denied for ip\b|` +
+		`\bnot (in|on) (the |your )?(api )?(acl|whitelist|allowlist)\b|\bnot (whitelisted|allowlisted)\b`)
+
+	// addressPattern matches candidate IPv4 and IPv6 addresses in a message
+	addressPattern = regexp.MustCompile(`[0-9A-Fa-f:.]*[:.][0-9A-Fa-f:.]+`)
+)
+
 func GetDomainFromZone(fqdn string) string {
 	return strings.TrimSuffix(fqdn, ".")
 }
 
 // GetZoneID returns the zone ID for a domain name, if it exists
-func GetZoneID(domainName string, apiKey string) (int, error) {
-	zoneList, err := DNSZoneGet(apiKey)
+func (c *Client) GetZoneID(ctx context.Context, domainName string, apiKey string) (int, error) {
+	zoneID, ok := c.zones.get(apiKey, domainName)
+	c.metrics.zoneCacheLookup(ok)
+
+	if ok {
+		c.log(ctx).DebugContext(ctx, "Zone ID cached", "zone", domainName, "zoneID", zoneID)
+
+		return zoneID, nil
+	}
+
+	zoneList, err := c.DNSZoneGet(ctx, apiKey)
 	if err != nil {
 		return 0, fmt.Errorf("error getting zone: %w", err)
 	}
 
+	c.zones.set(apiKey, zoneList.Data)
+
 	for _, zone := range zoneList.Data {
 		if strings.EqualFold(zone.Name, strings.TrimRight(domainName, ".")) {
+			c.log(ctx).DebugContext(ctx, "Zone ID found", "zone", domainName, "zoneID", zone.ID)
+
 			return zone.ID, nil
 		}
 	}
@@ -32,215 +59,237 @@ func GetZoneID(domainName string, apiKey string) (int, error) {
 
 // see https://docs.netactuate.com/reference/dns
 
-// DNSZoneGet returns a list of all DNS Zones for an account
-func DNSZoneGet(apiKey string) (*ZoneList, error) {
-	var err error
-
-	backgroundContext := context.Background()
-
-	url := "https://vapi2.netactuate.com/api/dns/zones?type=NATIVE&key=" + apiKey
+// DNSZoneGet returns a list of the DNS Zones of an account with the client's
+// zone type, see WithZoneType
+func (c *Client) DNSZoneGet(ctx context.Context, apiKey string) (*ZoneList, error) {
+	return c.DNSZoneList(ctx, apiKey, c.zoneType)
+}
 
-	var req *http.Request
+// DNSZoneList returns a list of the DNS Zones of an account with zoneType,
+// or of every type if zoneType is empty
+func (c *Client) DNSZoneList(ctx context.Context, apiKey string, zoneType string) (*ZoneList, error) {
+	query := url.Values{}
+	if zoneType != "" {
+		query.Set("type", zoneType)
+	}
 
-	req, err = http.NewRequestWithContext(backgroundContext, http.MethodGet, url, nil)
+	body, err := c.do(ctx, "dns_zones", http.MethodGet, "/api/dns/zones", apiKey, query)
 	if err != nil {
-		return nil, fmt.Errorf("error creating http request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Add("accept", "application/json")
+	return decodeResponse[[]ZoneSummary]("dns_zones", body)
+}
 
-	var res *http.Response
+// DNSZoneCreate creates a DNS Zone of zoneType for domainName and returns its
+// ID
+func (c *Client) DNSZoneCreate(ctx context.Context, apiKey string, domainName string, zoneType string) (int, error) {
+	query := url.Values{
+		"domain": {strings.TrimRight(domainName, ".")},
+		"type":   {zoneType},
+	}
 
-	res, err = http.DefaultClient.Do(req)
+	body, err := c.do(ctx, "dns_zone_post", http.MethodPost, "/api/dns/zone", apiKey, query)
 	if err != nil {
-		return nil, fmt.Errorf("error making http request: %w", err)
+		return 0, err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error response from netactuate api: %s, %w", res.Status, ErrHTTPNotOK)
+	response, err := decodeResponse[ZoneSummary]("dns_zone_post", body)
+	if err != nil {
+		return 0, err
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	if response.Code == http.StatusOK && response.Data.ID != 0 {
+		return response.Data.ID, nil
+	}
 
-	var body []byte
+	return 0, fmt.Errorf("error creating zone: %d %s, %w", response.Code, cmp.Or(response.Message, response.Result),
+		ErrUnknown)
+}
 
-	body, err = io.ReadAll(res.Body)
+// DNSZoneGetByID returns the details of a DNS Zone, its SOA, name servers
+// and serial
+func (c *Client) DNSZoneGetByID(ctx context.Context, apiKey string, zoneID int) (*ZoneDetail, error) {
+	body, err := c.do(ctx, "dns_zone", http.MethodGet, "/api/dns/zone/"+strconv.Itoa(zoneID), apiKey, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
+		return nil, err
 	}
 
-	var zoneList ZoneList
-
-	err = json.Unmarshal(body, &zoneList)
+	response, err := decodeResponse[ZoneDetail]("dns_zone", body)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling response body: %w", err)
+		return nil, err
 	}
 
-	return &zoneList, nil
-}
-
-// DNSRecordPost Adds a new DNS record to a Zone
-func DNSRecordPost(apiKey string, domainName string, recordType string, recordName string, recordContent string) error {
-	var err error
+	if response.Data.ID == 0 {
+		return nil, fmt.Errorf("error getting zone %d: %d %s, %w", zoneID, response.Code,
+			cmp.Or(response.Message, response.Result), ErrUnknown)
+	}
 
-	var zoneID int
+	return &response.Data, nil
+}
 
-	zoneID, err = GetZoneID(domainName, apiKey)
+// DNSZoneDelete deletes a DNS Zone and all its records
+func (c *Client) DNSZoneDelete(ctx context.Context, apiKey string, zoneID int) error {
+	body, err := c.do(ctx, "dns_zone_delete", http.MethodDelete, "/api/dns/zone/"+strconv.Itoa(zoneID), apiKey, nil)
 	if err != nil {
-		return fmt.Errorf("error getting zone ID: %w", err)
+		return err
 	}
 
-	url := "https://vapi2.netactuate.com/api/dns/record?domain_id=" + strconv.FormatInt(int64(zoneID), 10) +
-		"&name=" + strings.TrimRight(recordName, ".") + "&type=" + recordType + "&record_content=" +
-		recordContent + "&key=" + apiKey
-
-	backgroundContext := context.Background()
-
-	var req *http.Request
-
-	req, err = http.NewRequestWithContext(backgroundContext, http.MethodPost, url, nil)
+	_, err = decodeResponse[json.RawMessage]("dns_zone_delete", body)
 	if err != nil {
-		return fmt.Errorf("error creating http request: %w", err)
+		return err
 	}
 
-	req.Header.Add("accept", "application/json")
+	c.zones.forget(apiKey, zoneID)
 
-	var res *http.Response
+	return nil
+}
 
-	res, err = http.DefaultClient.Do(req)
+// DNSRecordPost Adds a new DNS record to a Zone and returns its ID. The
+// account's default TTL is used if ttl is 0.
+func (c *Client) DNSRecordPost(
+	ctx context.Context, apiKey string, domainName string, recordType string, recordName string, recordContent string,
+	ttl int,
+) (int, error) {
+	zoneID, err := c.GetZoneID(ctx, domainName, apiKey)
 	if err != nil {
-		return fmt.Errorf("error making http request: %w", err)
+		return 0, fmt.Errorf("error getting zone ID: %w", err)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	query := url.Values{
+		"domain_id":      {strconv.Itoa(zoneID)},
+		"name":           {strings.TrimRight(recordName, ".")},
+		"type":           {recordType},
+		"record_content": {recordContent},
+	}
 
-	var body []byte
+	if ttl != 0 {
+		query.Set("ttl", strconv.Itoa(ttl))
+	}
 
-	body, err = io.ReadAll(res.Body)
+	body, err := c.do(ctx, "dns_record_post", http.MethodPost, "/api/dns/record", apiKey, query)
 	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+		return 0, err
 	}
 
-	var dnsRecordPostResponse DNSRecordPostResponse
-
-	err = json.Unmarshal(body, &dnsRecordPostResponse)
+	response, err := decodeResponse[DNSRecordPostResponseData]("dns_record_post", body)
 	if err != nil {
-		return fmt.Errorf("error unmarshaling response body: %w", err)
+		return 0, err
 	}
 
-	if res.StatusCode == http.StatusOK && dnsRecordPostResponse.Code == http.StatusOK {
-		return nil
+	if response.Code == http.StatusOK && response.Data.ID != 0 {
+		return response.Data.ID, nil
 	}
 
-	return ErrUnknown
+	return 0, ErrUnknown
 }
 
 // DNSRecordsGet gets a list of DNS records for the given domain
-func DNSRecordsGet(apiKey string, domainName string) ([]DNSRecord, error) {
-	var err error
-
-	var zoneID int
-
-	zoneID, err = GetZoneID(domainName, apiKey)
+func (c *Client) DNSRecordsGet(ctx context.Context, apiKey string, domainName string) ([]DNSRecord, error) {
+	zoneID, err := c.GetZoneID(ctx, domainName, apiKey)
 	if err != nil {
 		return nil, fmt.Errorf("error getting zone ID: %w", err)
 	}
 
-	url := "https://vapi2.netactuate.com/api/dns/records/" + strconv.FormatInt(int64(zoneID), 10) + "?key=" + apiKey
-
-	backgroundContext := context.Background()
-
-	var req *http.Request
-
-	req, err = http.NewRequestWithContext(backgroundContext, http.MethodGet, url, nil)
+	body, err := c.do(ctx, "dns_records", http.MethodGet, "/api/dns/records/"+strconv.Itoa(zoneID), apiKey, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating http request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Add("accept", "application/json")
-
-	var res *http.Response
-
-	res, err = http.DefaultClient.Do(req)
+	response, err := decodeResponse[[]DNSRecord]("dns_records", body)
 	if err != nil {
-		return nil, fmt.Errorf("error making http request: %w", err)
+		return nil, err
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
-	var body []byte
+	return response.Data, nil
+}
 
-	body, err = io.ReadAll(res.Body)
+// DNSRecordGet gets a DNS record by ID
+func (c *Client) DNSRecordGet(ctx context.Context, apiKey string, recordID int) (*DNSRecord, error) {
+	body, err := c.do(ctx, "dns_record", http.MethodGet, "/api/dns/record/"+strconv.Itoa(recordID), apiKey, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
-
-	if res.StatusCode != http.StatusOK {
-		return nil, ErrHTTPNotOK
+		return nil, err
 	}
 
-	var dnsRecordListResponse DNSRecordListResponse
-
-	err = json.Unmarshal(body, &dnsRecordListResponse)
+	response, err := decodeResponse[DNSRecord]("dns_record", body)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling response body: %w", err)
+		return nil, err
 	}
 
-	return dnsRecordListResponse.Data, nil
+	return &response.Data, nil
 }
 
 // DNSRecordDelete deletes a DNS record
-func DNSRecordDelete(apiKey string, recordID int) error {
-	var err error
-
-	url := "https://vapi2.netactuate.com/api/dns/record/" + strconv.FormatInt(int64(recordID), 10) +
-		"?key=" + apiKey
+func (c *Client) DNSRecordDelete(ctx context.Context, apiKey string, recordID int) error {
+	body, err := c.do(ctx, "dns_record_delete", http.MethodDelete, "/api/dns/record/"+strconv.Itoa(recordID), apiKey, nil)
+	if err != nil {
+		return err
+	}
 
-	backgroundContext := context.Background()
+	_, err = decodeResponse[json.RawMessage]("dns_record_delete", body)
 
-	var req *http.Request
+	return err
+}
 
-	req, err = http.NewRequestWithContext(backgroundContext, http.MethodDelete, url, nil)
-	if err != nil {
-		return fmt.Errorf("error creating http request: %w", err)
+// checkStatus returns an error for responses without a 200 status. Keys the
+// API rejects are reported as ErrUnauthorized, and requests from addresses
+// missing from the account's API ACL as an *IPNotAllowedError.
+func checkStatus(res *http.Response, body []byte) error {
+	if res.StatusCode == http.StatusOK {
+		return nil
 	}
 
-	req.Header.Add("accept", "application/json")
+	var response struct {
+		Message string `json:"message"`
+	}
 
-	var res *http.Response
+	_ = json.Unmarshal(body, &response)
 
-	res, err = http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("error making http request: %w", err)
-	}
+	rejected := res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden
 
-	if res.StatusCode != http.StatusOK {
+	switch {
+	case rejected && ipRejectionPattern.MatchString(response.Message):
+		return newIPNotAllowedError(res.Status, response.Message)
+	case rejected:
+		return fmt.Errorf("error response from netactuate api: %s %s, %w", res.Status, response.Message, ErrUnauthorized)
+	default:
 		return fmt.Errorf("error response from netactuate api: %s, %w", res.Status, ErrHTTPNotOK)
 	}
+}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
-	var body []byte
+// checkCode returns ErrUnauthorized or an *IPNotAllowedError if the code in a
+// response body shows the request was rejected, and ErrHTTPNotOK for any
+// other code but 200. A body without a code is left to its caller.
+func checkCode(code int, message string) error {
+	rejected := code == http.StatusUnauthorized || code == http.StatusForbidden
 
-	body, err = io.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("error reading response body: %w", err)
+	switch {
+	case code == http.StatusOK:
+		return nil
+	case rejected && ipRejectionPattern.MatchString(message):
+		return newIPNotAllowedError(strconv.Itoa(code), message)
+	case rejected:
+		return fmt.Errorf("error response from netactuate api: %d %s, %w", code, message, ErrUnauthorized)
+	case code != 0:
+		return fmt.Errorf("error response from netactuate api: %d %s, %w", code, message, ErrHTTPNotOK)
+	default:
+		return nil
 	}
+}
 
-	var zoneList ZoneList
+// newIPNotAllowedError returns the error for an ACL rejection, with the
+// first address found in its message as the source address
+func newIPNotAllowedError(status string, message string) *IPNotAllowedError {
+	rejection := &IPNotAllowedError{Status: status, Message: message}
 
-	err = json.Unmarshal(body, &zoneList)
-	if err != nil {
-		return fmt.Errorf("error unmarshaling response body: %w", err)
+	for _, candidate := range addressPattern.FindAllString(message, -1) {
+		addr, err := netip.ParseAddr(strings.Trim(candidate, ".:"))
+		if err == nil {
+			rejection.SourceIP = addr.String()
+
+			break
+		}
 	}
 
-	return nil
+	return rejection
 }
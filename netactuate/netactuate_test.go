@@ -1,219 +1,292 @@
 package netactuate
 
 import (
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
 	"os"
-	"strconv"
+	"path/filepath"
+	"slices"
 	"testing"
-	"time"
+
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate/cassette"
 )
 
-var netactuateAPIKey = "your-api-key"
-var testDomain = "example.com"
+// recordCassettes records the cassettes in testdata/cassettes against the
+// NetActuate API, with the key in NETACTUATE_API_KEY and the zone in
+// TEST_DOMAIN, instead of replaying them
+var recordCassettes = flag.Bool("record", false, "record cassettes against the NetActuate API")
 
-func TestGetZoneID(t *testing.T) {
-	t.Parallel()
+const (
+	cassetteAPIKey = "test-key"
+	cassetteDomain = "example.com"
+	cassetteZoneID = 296650
+)
 
-	netactuateAPIKey = os.Getenv("NETACTUATE_API_KEY")
-	if netactuateAPIKey == "" || netactuateAPIKey == "your-api-key" {
-		t.Fatal("error getting api key")
-	}
+// newCassetteClient returns a client replaying the named cassette, or
+// recording it with -record, and the API key and domain to call it with. When
+// recording, the key and domain are scrubbed from the cassette.
+func newCassetteClient(t *testing.T, name string) (*Client, string, string) {
+	t.Helper()
+
+	apiKey := cassetteAPIKey
+	domain := cassetteDomain
+	mode := cassette.ModeReplay
 
-	testDomain = os.Getenv("TEST_DOMAIN")
-	if testDomain == "" || testDomain == "example.com" {
-		t.Fatal("error getting domain")
+	var options []cassette.Option
+
+	if *recordCassettes {
+		apiKey = os.Getenv("NETACTUATE_API_KEY")
+		domain = os.Getenv("TEST_DOMAIN")
+
+		if apiKey == "" || domain == "" {
+			t.Fatal("recording cassettes requires NETACTUATE_API_KEY and TEST_DOMAIN")
+		}
+
+		mode = cassette.ModeRecord
+		options = append(options,
+			cassette.WithReplacement(apiKey, cassette.Redacted),
+			cassette.WithReplacement(domain, cassetteDomain),
+		)
 	}
 
-	type args struct {
-		domainName string
-		apiKey     string
+	recorder, err := cassette.New(filepath.Join("testdata", "cassettes", name+".yaml"), mode, options...)
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	t.Cleanup(func() {
+		err := recorder.Save()
+		if err != nil {
+			t.Error(err)
+		}
+
+		if unused := recorder.Unused(); len(unused) > 0 {
+			t.Errorf("cassette %s has interactions that were not replayed: %v", name, unused)
+		}
+	})
+
+	client := NewClient(WithHTTPClient(&http.Client{Transport: recorder}), WithRetries(0, 0))
+
+	return client, apiKey, domain
+}
+
+func TestGetZoneID(t *testing.T) {
+	t.Parallel()
+
+	client, apiKey, domain := newCassetteClient(t, "get_zone_id")
+
 	tests := []struct {
+		wantErr error
 		name    string
-		args    args
+		domain  string
 		want    int
-		wantErr bool
 	}{
-		{
-			name:    testDomain,
-			args:    args{domainName: testDomain, apiKey: netactuateAPIKey},
-			want:    296650,
-			wantErr: false,
-		},
+		{name: "zone", domain: domain, want: cassetteZoneID},
+		{name: "fqdn", domain: domain + ".", want: cassetteZoneID},
+		{name: "missing zone", domain: "missing." + domain, wantErr: ErrDomainNotFound},
 	}
 
+	// the cases share the client's zone cache, so they run in order: the
+	// first lists the zones, the second is cached and the missing zone lists
+	// them again
 	for _, testCase := range tests {
-		t.Run(testCase.name, func(t *testing.T) {
-			t.Parallel()
+		got, err := client.GetZoneID(t.Context(), testCase.domain, apiKey)
+		if !errors.Is(err, testCase.wantErr) {
+			t.Errorf("%s: GetZoneID() error = %v, want %v", testCase.name, err, testCase.wantErr)
+		}
+
+		if got != testCase.want {
+			t.Errorf("%s: GetZoneID() = %v, want %v", testCase.name, got, testCase.want)
+		}
+	}
+}
 
-			got, err := GetZoneID(testCase.args.domainName, testCase.args.apiKey)
-			if (err != nil) != testCase.wantErr {
-				t.Errorf("GetZoneID() error = %v, wantErr %v", err, testCase.wantErr)
+func TestDNSRecordPost(t *testing.T) {
+	t.Parallel()
 
-				return
-			}
+	client, apiKey, domain := newCassetteClient(t, "dns_record_post")
 
-			if got != testCase.want {
-				t.Errorf("GetZoneID() = %v, want %v", got, testCase.want)
-			}
-		})
+	recordID, err := client.DNSRecordPost(t.Context(), apiKey, domain, "A", "test-cassette."+domain+".", "1.1.1.1", 0)
+	if err != nil {
+		t.Fatalf("DNSRecordPost() error = %v", err)
+	}
+
+	if recordID == 0 {
+		t.Errorf("DNSRecordPost() = 0, want the new record's ID")
+	}
+
+	err = client.DNSRecordDelete(t.Context(), apiKey, recordID)
+	if err != nil {
+		t.Errorf("DNSRecordDelete() error = %v", err)
 	}
 }
 
-func TestDNSRecordPost(t *testing.T) {
+func TestDNSRecordsGet(t *testing.T) {
 	t.Parallel()
 
-	netactuateAPIKey = os.Getenv("NETACTUATE_API_KEY")
-	if netactuateAPIKey == "" || netactuateAPIKey == "your-api-key" {
-		t.Fatal("error getting api key")
+	client, apiKey, domain := newCassetteClient(t, "dns_records_get")
+
+	records, err := client.DNSRecordsGet(t.Context(), apiKey, domain)
+	if err != nil {
+		t.Fatalf("DNSRecordsGet() error = %v", err)
 	}
 
-	testDomain = os.Getenv("TEST_DOMAIN")
-	if testDomain == "" || testDomain == "example.com" {
-		t.Fatal("error getting domain")
+	types := map[string]bool{}
+
+	for _, record := range records {
+		if record.ID == 0 || record.Name == "" {
+			t.Errorf("DNSRecordsGet() record without an ID or name: %+v", record)
+		}
+
+		types[record.RecordType] = true
 	}
 
-	type args struct {
-		apiKey        string
-		domainName    string
-		recordType    string
-		recordName    string
-		recordContent string
+	// every zone has an SOA and NS records
+	if !types["SOA"] || !types["NS"] {
+		t.Errorf("DNSRecordsGet() = %+v, want the zone's SOA and NS records", records)
 	}
+}
 
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "test1",
-			args: args{
-				apiKey:        netactuateAPIKey,
-				domainName:    testDomain,
-				recordType:    "A",
-				recordName:    "test" + strconv.FormatInt(time.Now().Unix(), 10),
-				recordContent: "1.1.1.1",
-			},
-		},
+func TestDNSRecordDelete(t *testing.T) {
+	t.Parallel()
+
+	client, apiKey, domain := newCassetteClient(t, "dns_record_delete")
+
+	recordID, err := client.DNSRecordPost(t.Context(), apiKey, domain, "TXT", "_acme-challenge.cassette."+domain+".",
+		"cassette-key", 0)
+	if err != nil {
+		t.Fatalf("DNSRecordPost() error = %v", err)
 	}
 
-	for _, testCase := range tests {
-		t.Run(testCase.name, func(t *testing.T) {
-			t.Parallel()
+	record, err := client.DNSRecordGet(t.Context(), apiKey, recordID)
+	if err != nil || record.ID != recordID || record.Content != "cassette-key" {
+		t.Fatalf("DNSRecordGet() = %+v, %v, want the posted record", record, err)
+	}
+
+	err = client.DNSRecordDelete(t.Context(), apiKey, recordID)
+	if err != nil {
+		t.Fatalf("DNSRecordDelete() error = %v", err)
+	}
 
-			err := DNSRecordPost(
-				testCase.args.apiKey,
-				testCase.args.domainName,
-				testCase.args.recordType,
-				testCase.args.recordName,
-				testCase.args.recordContent,
-			)
+	records, err := client.DNSRecordsGet(t.Context(), apiKey, domain)
+	if err != nil {
+		t.Fatalf("DNSRecordsGet() error = %v", err)
+	}
 
-			if (err != nil) != testCase.wantErr {
-				t.Errorf("DNSRecordPost() error = %v, wantErr %v", err, testCase.wantErr)
-			}
-		})
+	for _, listed := range records {
+		if listed.ID == recordID {
+			t.Errorf("DNSRecordsGet() after DNSRecordDelete() still lists %+v", listed)
+		}
 	}
 }
 
-func TestDNSRecordsGet(t *testing.T) {
+func TestDNSZoneLifecycle(t *testing.T) {
 	t.Parallel()
 
-	netactuateAPIKey = os.Getenv("NETACTUATE_API_KEY")
-	if netactuateAPIKey == "" || netactuateAPIKey == "your-api-key" {
-		t.Fatal("error getting api key")
-	}
+	client, apiKey, domain := newCassetteClient(t, "dns_zone_lifecycle")
+	zoneName := "zone-test." + domain
 
-	testDomain = os.Getenv("TEST_DOMAIN")
-	if testDomain == "" || testDomain == "example.com" {
-		t.Fatal("error getting domain")
+	zoneID, err := client.DNSZoneCreate(t.Context(), apiKey, zoneName+".", ZoneTypeNative)
+	if err != nil {
+		t.Fatalf("DNSZoneCreate() error = %v", err)
 	}
 
-	type args struct {
-		apiKey     string
-		domainName string
+	found, err := client.GetZoneID(t.Context(), zoneName, apiKey)
+	if err != nil || found != zoneID {
+		t.Fatalf("GetZoneID() of the created zone = %d, %v, want %d", found, err, zoneID)
 	}
 
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "test1",
-			args: args{
-				apiKey:     netactuateAPIKey,
-				domainName: testDomain,
-			},
-			wantErr: false,
-		},
+	zone, err := client.DNSZoneGetByID(t.Context(), apiKey, zoneID)
+	if err != nil {
+		t.Fatalf("DNSZoneGetByID() error = %v", err)
 	}
 
-	for _, testCase := range tests {
-		t.Run(testCase.name, func(t *testing.T) {
-			t.Parallel()
+	if zone.ID != zoneID || zone.Name != zoneName || len(zone.Nameservers) == 0 || zone.SOA.Serial == 0 {
+		t.Errorf("DNSZoneGetByID() = %+v, want the created zone with its name servers and SOA", zone)
+	}
 
-			_, err := DNSRecordsGet(testCase.args.apiKey, testCase.args.domainName)
-			if (err != nil) != testCase.wantErr {
-				t.Errorf("DNSRecordsGet() error = %v, wantErr %v", err, testCase.wantErr)
+	err = client.DNSZoneDelete(t.Context(), apiKey, zoneID)
+	if err != nil {
+		t.Fatalf("DNSZoneDelete() error = %v", err)
+	}
 
-				return
-			}
-		})
+	// the deleted zone is dropped from the zone cache, so the zones are
+	// listed again
+	_, err = client.GetZoneID(t.Context(), zoneName, apiKey)
+	if !errors.Is(err, ErrDomainNotFound) {
+		t.Errorf("GetZoneID() of the deleted zone error = %v, want %v", err, ErrDomainNotFound)
 	}
 }
 
-func TestDNSRecordDelete(t *testing.T) {
+func TestDNSZoneFailures(t *testing.T) {
 	t.Parallel()
 
-	netactuateAPIKey = os.Getenv("NETACTUATE_API_KEY")
-	if netactuateAPIKey == "" || netactuateAPIKey == "your-api-key" {
-		t.Fatal("error getting api key")
+	client, apiKey, domain := newCassetteClient(t, "dns_zone_failures")
+
+	_, err := client.DNSZoneCreate(t.Context(), apiKey, domain+".", ZoneTypeNative)
+	if !errors.Is(err, ErrHTTPNotOK) {
+		t.Errorf("DNSZoneCreate() of an existing zone error = %v, want %v", err, ErrHTTPNotOK)
 	}
 
-	testDomain = os.Getenv("TEST_DOMAIN")
-	if testDomain == "" || testDomain == "example.com." ||
-		testDomain == "example.com" || testDomain == "example.coM." {
-		t.Fatal("error getting domain")
+	zoneID, err := client.GetZoneID(t.Context(), domain, apiKey)
+	if err != nil || zoneID != cassetteZoneID {
+		t.Fatalf("GetZoneID() = %d, %v, want %d", zoneID, err, cassetteZoneID)
 	}
 
-	testRecordID := os.Getenv("TEST_RECORD_ID")
-	if testRecordID == "" || testRecordID == "123456" {
-		t.Fatal("error getting test record ID")
+	_, err = client.DNSZoneGetByID(t.Context(), apiKey, cassetteZoneID+1)
+	if !errors.Is(err, ErrHTTPNotOK) {
+		t.Errorf("DNSZoneGetByID() of a missing zone error = %v, want %v", err, ErrHTTPNotOK)
 	}
 
-	testRecordIDInt64, err := strconv.ParseInt(testRecordID, 10, 64)
-	if err != nil {
-		t.Fatal("error getting test record ID")
+	err = client.DNSZoneDelete(t.Context(), apiKey, cassetteZoneID)
+	if !errors.Is(err, ErrHTTPNotOK) {
+		t.Errorf("DNSZoneDelete() error = %v, want %v", err, ErrHTTPNotOK)
 	}
 
-	type args struct {
-		apiKey   string
-		recordID int
+	// a zone that failed to be deleted stays in the zone cache, so the zones
+	// are not listed again
+	zoneID, err = client.GetZoneID(t.Context(), domain, apiKey)
+	if err != nil || zoneID != cassetteZoneID {
+		t.Errorf("GetZoneID() after a failed delete = %d, %v, want %d", zoneID, err, cassetteZoneID)
 	}
+}
+
+func TestDNSZoneGetType(t *testing.T) {
+	t.Parallel()
 
 	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
+		name     string
+		options  []Option
+		wantType []string
 	}{
-		{
-			name: "test1",
-			args: args{
-				apiKey:   netactuateAPIKey,
-				recordID: int(testRecordIDInt64),
-			},
-		},
+		{name: "default", wantType: []string{ZoneTypeNative}},
+		{name: "master", options: []Option{WithZoneType(ZoneTypeMaster)}, wantType: []string{ZoneTypeMaster}},
+		{name: "all types", options: []Option{WithZoneType("")}},
 	}
 
 	for _, testCase := range tests {
 		t.Run(testCase.name, func(t *testing.T) {
 			t.Parallel()
 
-			err := DNSRecordDelete(testCase.args.apiKey, testCase.args.recordID)
-			if (err != nil) != testCase.wantErr {
-				t.Errorf("DNSRecordDelete() error = %v, wantErr %v", err, testCase.wantErr)
+			var zoneType []string
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				zoneType = r.URL.Query()["type"]
+				_, _ = w.Write([]byte(`{"result": "success", "code": 200, "data": []}`))
+			}))
+			defer server.Close()
+
+			client := NewClient(append(testCase.options, WithBaseURL(server.URL))...)
+
+			_, err := client.DNSZoneGet(t.Context(), "test-key")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(zoneType, testCase.wantType) {
+				t.Errorf("zones listed with type %v, want %v", zoneType, testCase.wantType)
 			}
 		})
 	}
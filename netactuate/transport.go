@@ -0,0 +1,137 @@
+package netactuate
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	defaultDialTimeout           = 10 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultResponseHeaderTimeout = 30 * time.Second
+	defaultRequestTimeout        = time.Minute
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultKeepAlive             = 30 * time.Second
+	defaultMaxIdleConns          = 10
+)
+
+// TransportConfig configures the HTTP transport used for NetActuate API
+// calls. Zero values use the defaults.
+type TransportConfig struct {
+	// ProxyURL is the proxy requests are sent through, with its credentials
+	// as user info. The HTTPS_PROXY and NO_PROXY environment variables are
+	// used if it is nil.
+	ProxyURL *url.URL
+
+	// CABundleFile is a PEM file of CA certificates trusted in addition to
+	// the system roots.
+	CABundleFile string
+
+	// DialTimeout limits connecting to NetActuate or the proxy, 10 seconds by
+	// default.
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout limits the TLS handshake, 10 seconds by default.
+	TLSHandshakeTimeout time.Duration
+
+	// ResponseHeaderTimeout limits the wait for the response headers after
+	// the request is sent, 30 seconds by default.
+	ResponseHeaderTimeout time.Duration
+
+	// Timeout limits each request, including reading the response body, 1
+	// minute by default.
+	Timeout time.Duration
+
+	// IdleConnTimeout is how long an idle connection is kept, 90 seconds by
+	// default.
+	IdleConnTimeout time.Duration
+
+	// MaxIdleConns limits the idle connections kept, 10 by default.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost limits the idle connections kept to each host, 10
+	// by default.
+	MaxIdleConnsPerHost int
+
+	// TLSMinVersion is the minimum TLS version, tls.VersionTLS12 by default.
+	TLSMinVersion uint16
+}
+
+// NewHTTPClient returns an http.Client with a dedicated transport configured
+// by config
+func NewHTTPClient(config TransportConfig) (*http.Client, error) {
+	var rootCAs *x509.CertPool
+
+	if config.CABundleFile != "" {
+		var err error
+
+		rootCAs, err = loadCABundle(config.CABundleFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newHTTPClient(config, rootCAs), nil
+}
+
+// newHTTPClient returns an http.Client with a dedicated transport that trusts
+// rootCAs, or the system roots if rootCAs is nil
+func newHTTPClient(config TransportConfig, rootCAs *x509.CertPool) *http.Client {
+	proxy := http.ProxyFromEnvironment
+	if config.ProxyURL != nil {
+		proxy = http.ProxyURL(config.ProxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   cmp.Or(config.DialTimeout, defaultDialTimeout),
+		KeepAlive: defaultKeepAlive,
+	}
+
+	transport := &http.Transport{
+		Proxy:       proxy,
+		DialContext: dialer.DialContext,
+		TLSClientConfig: &tls.Config{
+			MinVersion: cmp.Or(config.TLSMinVersion, tls.VersionTLS12),
+			RootCAs:    rootCAs,
+		},
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   cmp.Or(config.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: cmp.Or(config.ResponseHeaderTimeout, defaultResponseHeaderTimeout),
+		IdleConnTimeout:       cmp.Or(config.IdleConnTimeout, defaultIdleConnTimeout),
+		MaxIdleConns:          cmp.Or(config.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   cmp.Or(config.MaxIdleConnsPerHost, defaultMaxIdleConns),
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cmp.Or(config.Timeout, defaultRequestTimeout),
+	}
+}
+
+// loadCABundle returns the system roots with the certificates in the PEM file
+// at path added
+func loadCABundle(path string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ca bundle: %w", err)
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	if !rootCAs.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("ca bundle %s: %w", path, ErrInvalidCABundle)
+	}
+
+	return rootCAs, nil
+}
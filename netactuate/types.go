@@ -1,5 +1,33 @@
 package netactuate
 
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Envelope is the body of every API response, Data holding the endpoint's
+// result. Code and Data may be missing or null, and Code may be encoded as a
+// string.
+type Envelope[T any] struct {
+	Data    T      `json:"data"`
+	Result  string `json:"result"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// Zone types, the types a zone is created with and zones are listed by
+const (
+	ZoneTypeNative = "NATIVE"
+	ZoneTypeMaster = "MASTER"
+	ZoneTypeSlave  = "SLAVE"
+)
+
+// ZoneList is the response listing the zones of an account
+type ZoneList = Envelope[[]ZoneSummary]
+
 type ZoneSummary struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
@@ -7,11 +35,26 @@ type ZoneSummary struct {
 	TTL  int    `json:"ttl"`
 }
 
-type ZoneList struct {
-	Result  string        `json:"result"`
-	Message string        `json:"message"`
-	Data    []ZoneSummary `json:"data"`
-	Code    int           `json:"code"`
+// ZoneDetail is a zone with its SOA record, name servers and serial
+type ZoneDetail struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Nameservers []string `json:"nameservers"`
+	SOA         ZoneSOA  `json:"soa"`
+	ID          int      `json:"id"`
+	TTL         int      `json:"ttl"`
+	Serial      int      `json:"serial"`
+}
+
+// ZoneSOA is the SOA record of a zone
+type ZoneSOA struct {
+	PrimaryNS  string `json:"primary"`
+	Hostmaster string `json:"hostmaster"`
+	Serial     int    `json:"serial"`
+	Refresh    int    `json:"refresh"`
+	Retry      int    `json:"retry"`
+	Expire     int    `json:"expire"`
+	MinimumTTL int    `json:"minimum"`
 }
 
 type DNSRecordPostResponseData struct {
@@ -23,12 +66,6 @@ type DNSRecordPostResponseData struct {
 	ID       int    `json:"id"`
 }
 
-type DNSRecordPostResponse struct {
-	Result string                    `json:"result"`
-	Data   DNSRecordPostResponseData `json:"data"`
-	Code   int                       `json:"code"`
-}
-
 type DNSRecord struct {
 	Name       string `json:"name"`
 	RecordType string `json:"type"`
@@ -37,9 +74,214 @@ type DNSRecord struct {
 	TTL        int    `json:"ttl"`
 }
 
-type DNSRecordListResponse struct {
-	Result  string      `json:"result"`
-	Message string      `json:"message"`
-	Data    []DNSRecord `json:"data"`
-	Code    int         `json:"code"`
+// UnmarshalJSON decodes an envelope, leaving Data zero if it is null
+func (e *Envelope[T]) UnmarshalJSON(raw []byte) error {
+	var decoded struct {
+		Data    json.RawMessage `json:"data"`
+		Result  string          `json:"result"`
+		Message string          `json:"message"`
+		Code    number          `json:"code"`
+	}
+
+	err := json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return fmt.Errorf("envelope: %w", err)
+	}
+
+	e.Result = decoded.Result
+	e.Message = decoded.Message
+	e.Code = int(decoded.Code)
+
+	if len(decoded.Data) == 0 || bytes.Equal(decoded.Data, []byte("null")) {
+		return nil
+	}
+
+	err = json.Unmarshal(decoded.Data, &e.Data)
+	if err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+
+	return nil
+}
+
+// UnmarshalJSON decodes a zone, accepting numbers encoded as strings
+func (z *ZoneSummary) UnmarshalJSON(raw []byte) error {
+	type zoneSummary ZoneSummary
+
+	type zoneSummaryJSON struct {
+		*zoneSummary
+
+		ID  number `json:"id"`
+		TTL number `json:"ttl"`
+	}
+
+	decoded := zoneSummaryJSON{zoneSummary: (*zoneSummary)(z)}
+
+	err := json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return fmt.Errorf("zone: %w", err)
+	}
+
+	z.ID = int(decoded.ID)
+	z.TTL = int(decoded.TTL)
+
+	return nil
+}
+
+// UnmarshalJSON decodes a zone's details, accepting numbers encoded as
+// strings
+func (z *ZoneDetail) UnmarshalJSON(raw []byte) error {
+	type zoneDetail ZoneDetail
+
+	type zoneDetailJSON struct {
+		*zoneDetail
+
+		ID     number `json:"id"`
+		TTL    number `json:"ttl"`
+		Serial number `json:"serial"`
+	}
+
+	decoded := zoneDetailJSON{zoneDetail: (*zoneDetail)(z)}
+
+	err := json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return fmt.Errorf("zone: %w", err)
+	}
+
+	z.ID = int(decoded.ID)
+	z.TTL = int(decoded.TTL)
+	z.Serial = int(decoded.Serial)
+
+	return nil
+}
+
+// UnmarshalJSON decodes a zone's SOA record, accepting numbers encoded as
+// strings
+func (s *ZoneSOA) UnmarshalJSON(raw []byte) error {
+	type zoneSOA ZoneSOA
+
+	type zoneSOAJSON struct {
+		*zoneSOA
+
+		Serial     number `json:"serial"`
+		Refresh    number `json:"refresh"`
+		Retry      number `json:"retry"`
+		Expire     number `json:"expire"`
+		MinimumTTL number `json:"minimum"`
+	}
+
+	decoded := zoneSOAJSON{zoneSOA: (*zoneSOA)(s)}
+
+	err := json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return fmt.Errorf("soa: %w", err)
+	}
+
+	s.Serial = int(decoded.Serial)
+	s.Refresh = int(decoded.Refresh)
+	s.Retry = int(decoded.Retry)
+	s.Expire = int(decoded.Expire)
+	s.MinimumTTL = int(decoded.MinimumTTL)
+
+	return nil
+}
+
+// UnmarshalJSON decodes a posted record, accepting numbers encoded as strings
+func (d *DNSRecordPostResponseData) UnmarshalJSON(raw []byte) error {
+	type postResponseData DNSRecordPostResponseData
+
+	type postResponseDataJSON struct {
+		*postResponseData
+
+		DomainID number `json:"domain_id"`
+		TTL      number `json:"ttl"`
+		ID       number `json:"id"`
+	}
+
+	decoded := postResponseDataJSON{postResponseData: (*postResponseData)(d)}
+
+	err := json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return fmt.Errorf("record: %w", err)
+	}
+
+	d.DomainID = int(decoded.DomainID)
+	d.TTL = int(decoded.TTL)
+	d.ID = int(decoded.ID)
+
+	return nil
+}
+
+// UnmarshalJSON decodes a record, accepting numbers encoded as strings
+func (r *DNSRecord) UnmarshalJSON(raw []byte) error {
+	type dnsRecord DNSRecord
+
+	type dnsRecordJSON struct {
+		*dnsRecord
+
+		ID  number `json:"id"`
+		TTL number `json:"ttl"`
+	}
+
+	decoded := dnsRecordJSON{dnsRecord: (*dnsRecord)(r)}
+
+	err := json.Unmarshal(raw, &decoded)
+	if err != nil {
+		return fmt.Errorf("record: %w", err)
+	}
+
+	r.ID = int(decoded.ID)
+	r.TTL = int(decoded.TTL)
+
+	return nil
+}
+
+// number is an integer the API may encode as a JSON number or a string. An
+// empty string or null is 0.
+type number int
+
+func (n *number) UnmarshalJSON(raw []byte) error {
+	value := string(raw)
+
+	if value == "null" {
+		return nil
+	}
+
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	if value == "" {
+		*n = 0
+
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%s is not an integer, %w", raw, strconv.ErrSyntax)
+	}
+
+	*n = number(parsed)
+
+	return nil
+}
+
+// decodeResponse decodes the body of a response from endpoint and checks
+// the code it holds. Bodies that cannot be decoded are reported as
+// ErrMalformedResponse.
+func decodeResponse[T any](endpoint string, body []byte) (*Envelope[T], error) {
+	var envelope Envelope[T]
+
+	err := json.Unmarshal(body, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s response: %w: %w", endpoint, ErrMalformedResponse, err)
+	}
+
+	err = checkCode(envelope.Code, cmp.Or(envelope.Message, envelope.Result))
+	if err != nil {
+		return nil, err
+	}
+
+	return &envelope, nil
 }
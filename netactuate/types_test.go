@@ -0,0 +1,107 @@
+package netactuate
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// updateGolden rewrites the golden files in testdata/responses from the
+// decoded fixtures
+var updateGolden = flag.Bool("update", false, "update the golden files of the response fixtures")
+
+// decodeFixture decodes a response fixture as the endpoint it is stored for
+// and returns the decoded envelope
+func decodeFixture(endpoint string, body []byte) (any, error) {
+	switch endpoint {
+	case "zones":
+		return decodeResponse[[]ZoneSummary](endpoint, body)
+	case "zone":
+		return decodeResponse[ZoneDetail](endpoint, body)
+	case "zone_post":
+		return decodeResponse[ZoneSummary](endpoint, body)
+	case "record_post":
+		return decodeResponse[DNSRecordPostResponseData](endpoint, body)
+	case "record":
+		return decodeResponse[DNSRecord](endpoint, body)
+	case "records":
+		return decodeResponse[[]DNSRecord](endpoint, body)
+	case "record_delete":
+		return decodeResponse[json.RawMessage](endpoint, body)
+	default:
+		return nil, os.ErrNotExist
+	}
+}
+
+// TestResponseFixtures decodes every fixture in testdata/responses and
+// compares the result, the decoded envelope or the error, with the fixture's
+// golden file. Fixtures named malformed* or truncated must fail with
+// ErrMalformedResponse, fixtures named error* must fail.
+func TestResponseFixtures(t *testing.T) {
+	t.Parallel()
+
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "responses", "*", "*.json"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no response fixtures found: %v", err)
+	}
+
+	for _, fixture := range fixtures {
+		endpoint := filepath.Base(filepath.Dir(fixture))
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+
+		t.Run(endpoint+"/"+name, func(t *testing.T) {
+			t.Parallel()
+
+			body, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []byte
+
+			decoded, err := decodeFixture(endpoint, body)
+
+			malformed := strings.HasPrefix(name, "malformed") || name == "truncated"
+			if malformed != errors.Is(err, ErrMalformedResponse) {
+				t.Errorf("decoding %s error = %v, want malformed %v", fixture, err, malformed)
+			}
+
+			if strings.HasPrefix(name, "error") && err == nil {
+				t.Errorf("decoding %s succeeded, want an error", fixture)
+			}
+
+			if err != nil {
+				got = []byte("error: " + err.Error() + "\n")
+			} else {
+				got, err = json.MarshalIndent(decoded, "", "  ")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got = append(got, '\n')
+			}
+
+			golden := strings.TrimSuffix(fixture, ".json") + ".golden"
+
+			if *updateGolden {
+				err = os.WriteFile(golden, got, 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != string(want) {
+				t.Errorf("decoded %s:\n%s\nwant:\n%s", fixture, got, want)
+			}
+		})
+	}
+}
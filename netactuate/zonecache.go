@@ -0,0 +1,87 @@
+package netactuate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zoneCache caches zone IDs by API key and zone name, so records can be
+// managed without listing every zone of the account each time
+type zoneCache struct {
+	entries map[string]zoneCacheEntry
+	ttl     time.Duration
+	mu      sync.Mutex
+}
+
+type zoneCacheEntry struct {
+	expires time.Time
+	id      int
+}
+
+func newZoneCache(ttl time.Duration) *zoneCache {
+	return &zoneCache{
+		entries: map[string]zoneCacheEntry{},
+		ttl:     ttl,
+	}
+}
+
+// get returns the cached ID of a zone
+func (z *zoneCache) get(apiKey string, zoneName string) (int, bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	entry, ok := z.entries[zoneCacheKey(apiKey, zoneName)]
+	if !ok || time.Now().After(entry.expires) {
+		return 0, false
+	}
+
+	return entry.id, true
+}
+
+// set caches the IDs of the zones listed for an API key
+func (z *zoneCache) set(apiKey string, zones []ZoneSummary) {
+	if z.ttl <= 0 {
+		return
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	now := time.Now()
+	expires := now.Add(z.ttl)
+
+	for key, entry := range z.entries {
+		if now.After(entry.expires) {
+			delete(z.entries, key)
+		}
+	}
+
+	for _, zone := range zones {
+		z.entries[zoneCacheKey(apiKey, zone.Name)] = zoneCacheEntry{expires: expires, id: zone.ID}
+	}
+}
+
+// forget drops a zone of an API key from the cache
+func (z *zoneCache) forget(apiKey string, zoneID int) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	prefix := zoneCacheKey(apiKey, "")
+
+	for key, entry := range z.entries {
+		if entry.id == zoneID && strings.HasPrefix(key, prefix) {
+			delete(z.entries, key)
+		}
+	}
+}
+
+// zoneCacheKey identifies a zone of an account without keeping the API key
+// in memory in the clear
+func zoneCacheKey(apiKey string, zoneName string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+
+	return hex.EncodeToString(sum[:]) + "/" + strings.ToLower(strings.TrimRight(zoneName, "."))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ownerRecordPrefix is prepended to the name of a challenge record to
+	// name its owner marker
+	ownerRecordPrefix = "_acme-owner."
+	ownerHeritage     = "netactuate-webhook"
+)
+
+// ownerMarker is the content of the TXT record that marks a challenge record
+// as created by this webhook. The marker is a separate record, named after
+// the challenge record, as the NetActuate API has no record comments. It
+// names the cluster and webhook that own the record, and a hash of the
+// challenge key so markers of challenges sharing a record name can be told
+// apart.
+type ownerMarker struct {
+	created time.Time
+	cluster string
+	webhook string
+	keyHash string
+}
+
+// String returns the marker as TXT record content
+func (m ownerMarker) String() string {
+	return fmt.Sprintf("heritage=%s,cluster=%s,webhook=%s,key=%s,created=%d",
+		ownerHeritage, m.cluster, m.webhook, m.keyHash, m.created.Unix())
+}
+
+// parseOwnerMarker parses the content of a marker record, reporting false if
+// it is not a marker
+func parseOwnerMarker(content string) (ownerMarker, bool) {
+	fields := map[string]string{}
+
+	for field := range strings.SplitSeq(strings.Trim(content, `"`), ",") {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return ownerMarker{}, false
+		}
+
+		fields[name] = value
+	}
+
+	created, err := strconv.ParseInt(fields["created"], 10, 64)
+	if err != nil || fields["heritage"] != ownerHeritage || fields["key"] == "" {
+		return ownerMarker{}, false
+	}
+
+	return ownerMarker{
+		created: time.Unix(created, 0),
+		cluster: fields["cluster"],
+		webhook: fields["webhook"],
+		keyHash: fields["key"],
+	}, true
+}
+
+// newOwnerMarker returns the marker for a challenge key created now
+func (c *customDNSProviderSolver) newOwnerMarker(key string) ownerMarker {
+	return ownerMarker{
+		created: time.Now(),
+		cluster: c.settings.ownerID,
+		webhook: c.settings.groupName,
+		keyHash: keyHash(key),
+	}
+}
+
+// owns reports whether a marker was written by this webhook in this
+// cluster
+func (c *customDNSProviderSolver) owns(marker ownerMarker) bool {
+	return marker.cluster == c.settings.ownerID && marker.webhook == c.settings.groupName
+}
+
+// challengeRecords are a challenge's TXT record and its owner marker, either
+// of which has a zero ID if it does not exist. foreign is set if a marker for
+// the challenge's key was written by another cluster or webhook.
+type challengeRecords struct {
+	record  netactuate.DNSRecord
+	marker  netactuate.DNSRecord
+	foreign bool
+}
+
+// matchChallengeRecords finds a challenge's records in the records of its
+// zone. Markers written by other clusters or webhooks are ignored.
+func (c *customDNSProviderSolver) matchChallengeRecords(
+	records []netactuate.DNSRecord, challengeRequest *v1alpha1.ChallengeRequest,
+) challengeRecords {
+	var found challengeRecords
+
+	recordName := netactuate.GetDomainFromZone(challengeRequest.ResolvedFQDN)
+	markerName := ownerRecordPrefix + recordName
+	hash := keyHash(challengeRequest.Key)
+
+	for _, record := range records {
+		if record.RecordType != "TXT" {
+			continue
+		}
+
+		switch record.Name {
+		case recordName:
+			if record.Content == challengeRequest.Key {
+				found.record = record
+			}
+		case markerName:
+			marker, ok := parseOwnerMarker(record.Content)
+			if !ok || marker.keyHash != hash {
+				continue
+			}
+
+			if c.owns(marker) {
+				found.marker = record
+			} else {
+				found.foreign = true
+			}
+		}
+	}
+
+	return found
+}
+
+// adopts reports whether CleanUp may delete a challenge record without an
+// owner marker. Records created before owner markers existed have none, they
+// are adopted while ADOPT_UNMARKED_RECORDS is set if their name and content
+// match the challenge exactly and no other cluster or webhook marked them.
+func (c *customDNSProviderSolver) adopts(found challengeRecords) bool {
+	return c.settings.adoptUnmarkedRecords && found.record.ID != 0 && found.marker.ID == 0 && !found.foreign
+}
+
+// keyHash identifies a challenge key in its marker without revealing it
+func keyHash(key string) string {
+	return shortHash(key)
+}
+
+// challengeID identifies a challenge by its record name and key. cert-manager
+// leaves the UID of challenge requests empty, so it cannot be used.
+func challengeID(challengeRequest *v1alpha1.ChallengeRequest) string {
+	return shortHash(normalizeZone(challengeRequest.ResolvedFQDN) + " " + challengeRequest.Key)
+}
+
+// shortHash returns a truncated SHA-256 hash of value
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// clusterID returns a hash of the UID of the kube-system namespace, which
+// identifies the cluster when no OWNER_ID is set. Markers are public TXT
+// records, so the UID itself is not published.
+func clusterID(ctx context.Context, client kubernetes.Interface) (string, error) {
+	namespace, err := client.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting cluster id: %w", err)
+	}
+
+	return shortHash(string(namespace.UID)), nil
+}
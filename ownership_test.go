@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseOwnerMarker(t *testing.T) {
+	t.Parallel()
+
+	marker := ownerMarker{
+		created: time.Unix(1700000000, 0),
+		cluster: "cluster-uid",
+		webhook: "acme.example.com",
+		keyHash: keyHash("token"),
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    ownerMarker
+		wantOK  bool
+	}{
+		{name: "marker", content: marker.String(), want: marker, wantOK: true},
+		{name: "quoted", content: `"` + marker.String() + `"`, want: marker, wantOK: true},
+		{name: "challenge key", content: "token", wantOK: false},
+		{name: "other heritage", content: "heritage=external-dns,external-dns/owner=default", wantOK: false},
+		{name: "no created time", content: "heritage=netactuate-webhook,cluster=a,webhook=b,key=c", wantOK: false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := parseOwnerMarker(testCase.content)
+			if ok != testCase.wantOK || !got.created.Equal(testCase.want.created) || got.cluster != testCase.want.cluster ||
+				got.webhook != testCase.want.webhook || got.keyHash != testCase.want.keyHash {
+				t.Errorf("parseOwnerMarker() = %+v, %v, want %+v, %v", got, ok, testCase.want, testCase.wantOK)
+			}
+		})
+	}
+}
+
+func TestCleanUpOwnership(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+
+	otherCluster := newTestSolver(t, api)
+	otherCluster.settings.ownerID = "other-cluster"
+
+	// a record with the challenge's key that this webhook did not create
+	unowned := newTestChallenge("unowned", "unowned-key")
+	api.addRecord("_acme-challenge.unowned."+fakeZone, "unowned-key")
+
+	err := solver.CleanUp(unowned)
+	if !errors.Is(err, ErrRecordNotOwned) {
+		t.Errorf("CleanUp() of an unmarked record error = %v, want %v", err, ErrRecordNotOwned)
+	}
+
+	owned := newTestChallenge("owned", "owned-key")
+
+	err = solver.Present(owned)
+	if err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+
+	err = otherCluster.CleanUp(owned)
+	if !errors.Is(err, ErrRecordNotOwned) {
+		t.Errorf("CleanUp() by another cluster error = %v, want %v", err, ErrRecordNotOwned)
+	}
+
+	err = solver.CleanUp(owned)
+	if err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+
+	records := api.recordList()
+	if len(records) != 1 || records[0].Content != "unowned-key" {
+		t.Errorf("records after CleanUp = %+v, want only the unowned record", records)
+	}
+}
+
+func TestCleanUpAdoption(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+	solver.settings.adoptUnmarkedRecords = true
+
+	otherCluster := newTestSolver(t, api)
+	otherCluster.settings.ownerID = "other-cluster"
+	otherCluster.settings.adoptUnmarkedRecords = true
+
+	// a record created before owner markers existed
+	unmarked := newTestChallenge("unmarked", "unmarked-key")
+	api.addRecord("_acme-challenge.unmarked."+fakeZone, "unmarked-key")
+
+	// a record whose content does not match the challenge's key
+	mismatched := newTestChallenge("mismatched", "mismatched-key")
+	api.addRecord("_acme-challenge.mismatched."+fakeZone, "other-key")
+
+	owned := newTestChallenge("owned", "owned-key")
+
+	err := solver.Present(owned)
+	if err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+
+	err = otherCluster.CleanUp(owned)
+	if !errors.Is(err, ErrRecordNotOwned) {
+		t.Errorf("CleanUp() by another cluster error = %v, want %v", err, ErrRecordNotOwned)
+	}
+
+	err = solver.CleanUp(mismatched)
+	if !errors.Is(err, ErrTXTRecordNotFound) {
+		t.Errorf("CleanUp() of a mismatched record error = %v, want %v", err, ErrTXTRecordNotFound)
+	}
+
+	err = solver.CleanUp(unmarked)
+	if err != nil {
+		t.Fatalf("CleanUp() of an unmarked record error = %v", err)
+	}
+
+	err = solver.CleanUp(owned)
+	if err != nil {
+		t.Fatalf("CleanUp() error = %v", err)
+	}
+
+	records := api.recordList()
+	if len(records) != 1 || records[0].Content != "other-key" {
+		t.Errorf("records after CleanUp = %+v, want only the mismatched record", records)
+	}
+}
+
+func TestClusterID(t *testing.T) {
+	t.Parallel()
+
+	const uid = "5f3c2a1e-8d7b-4c6a-9e0f-1a2b3c4d5e6f"
+
+	client := fake.NewClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: metav1.NamespaceSystem, UID: uid},
+	})
+
+	id, err := clusterID(t.Context(), client)
+	if err != nil {
+		t.Fatalf("clusterID() error = %v", err)
+	}
+
+	if id != shortHash(uid) || len(id) != 16 {
+		t.Errorf("clusterID() = %q, want the truncated hash %q", id, shortHash(uid))
+	}
+
+	// markers are public, the kube-system UID must not be published
+	marker := ownerMarker{created: time.Now(), cluster: id, webhook: "acme.example.com", keyHash: keyHash("token")}
+	if strings.Contains(marker.String(), uid) {
+		t.Errorf("marker %q contains the kube-system UID", marker)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// authorizationPolicy limits the zones and record names that challenges of
+// each namespace may solve for. The namespace of a challenge is its
+// ResourceNamespace, the Issuer's namespace, or cert-manager's cluster
+// resource namespace for ClusterIssuers. A challenge is authorized if its
+// zone is not denied and a rule matches its namespace, zone and name. A nil
+// *authorizationPolicy authorizes every challenge.
+type authorizationPolicy struct {
+	client kubernetes.Interface
+
+	// DeniedZones are never solved for, whatever the rules say. A zone also
+	// denies its subdomains, whether or not they are hosted as zones of their
+	// own.
+	DeniedZones []string `json:"deniedZones,omitempty"`
+
+	Rules []policyRule `json:"rules"`
+}
+
+// policyRule authorizes namespaces to solve for zones. A rule matches the
+// namespaces listed in Namespaces, "*" for all, and those whose labels match
+// NamespaceSelector. Zones match the challenge's record name, so they also
+// match subdomains of the zone hosting the record. Names optionally
+// limits the rule to record names, regular expressions that must match the
+// whole of the challenge's record name, such as
+// _acme-challenge.www.example.com.
+type policyRule struct {
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	selector labels.Selector
+
+	Namespaces []string `json:"namespaces,omitempty"`
+	Zones      []string `json:"zones"`
+	Names      []string `json:"names,omitempty"`
+
+	names []*regexp.Regexp
+}
+
+// loadPolicy reads and validates the policy file at path
+func loadPolicy(path string, client kubernetes.Interface) (*authorizationPolicy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy file: %w", err)
+	}
+
+	return parsePolicy(raw, client)
+}
+
+// parsePolicy decodes a policy, rejecting unknown fields, and validates it
+func parsePolicy(raw []byte, client kubernetes.Interface) (*authorizationPolicy, error) {
+	policy := &authorizationPolicy{client: client}
+
+	err := yaml.UnmarshalStrict(raw, policy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decoding policy: %w", ErrInvalidPolicy, err)
+	}
+
+	errs := policy.compile()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPolicy, errs.ToAggregate())
+	}
+
+	return policy, nil
+}
+
+// compile validates the policy and prepares its selectors and name patterns
+func (p *authorizationPolicy) compile() field.ErrorList {
+	var errs field.ErrorList
+
+	for i, zone := range p.DeniedZones {
+		errs = append(errs, validateZone(zone, field.NewPath("deniedZones").Index(i))...)
+	}
+
+	if len(p.Rules) == 0 {
+		errs = append(errs, field.Required(field.NewPath("rules"), "at least one rule must be set"))
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		path := field.NewPath("rules").Index(i)
+
+		if len(rule.Namespaces) == 0 && rule.NamespaceSelector == nil {
+			errs = append(errs, field.Required(path, "namespaces or namespaceSelector must be set"))
+		}
+
+		for j, namespace := range rule.Namespaces {
+			if namespace != "*" {
+				errs = append(errs, validateNamespace(namespace, path.Child("namespaces").Index(j))...)
+			}
+		}
+
+		if rule.NamespaceSelector != nil {
+			var err error
+
+			rule.selector, err = metav1.LabelSelectorAsSelector(rule.NamespaceSelector)
+			if err != nil {
+				errs = append(errs, field.Invalid(path.Child("namespaceSelector"), rule.NamespaceSelector, err.Error()))
+			}
+		}
+
+		if len(rule.Zones) == 0 {
+			errs = append(errs, field.Required(path.Child("zones"), "at least one zone must be set"))
+		}
+
+		for j, zone := range rule.Zones {
+			errs = append(errs, validateZone(zone, path.Child("zones").Index(j))...)
+		}
+
+		for j, name := range rule.Names {
+			pattern, err := compileFullMatch(name)
+			if err != nil {
+				errs = append(errs, field.Invalid(path.Child("names").Index(j), name, err.Error()))
+
+				continue
+			}
+
+			rule.names = append(rule.names, pattern)
+		}
+	}
+
+	return errs
+}
+
+// validateZone checks that a zone is a valid domain name
+func validateZone(zone string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for _, msg := range validation.IsDNS1123Subdomain(normalizeZone(zone)) {
+		errs = append(errs, field.Invalid(path, zone, msg))
+	}
+
+	return errs
+}
+
+// authorize returns ErrNotAuthorized if the policy does not allow the
+// challenge's namespace to solve for its record name. Zones are matched
+// against the record name rather than the zone hosting it.
+func (p *authorizationPolicy) authorize(ctx context.Context, challengeRequest *v1alpha1.ChallengeRequest) error {
+	if p == nil {
+		return nil
+	}
+
+	namespace := challengeRequest.ResourceNamespace
+	zone := normalizeZone(challengeRequest.ResolvedZone)
+	name := normalizeZone(challengeRequest.ResolvedFQDN)
+
+	for _, denied := range p.DeniedZones {
+		if zoneHasSuffix(name, normalizeZone(denied)) {
+			return fmt.Errorf("zone %s is denied by policy, %w", normalizeZone(denied), ErrNotAuthorized)
+		}
+	}
+
+	// the namespace's labels are only read if a rule needs them
+	var namespaceLabels labels.Set
+
+	labelsRead := false
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.matchesZone(name) || !rule.matchesName(name) {
+			continue
+		}
+
+		if slices.Contains(rule.Namespaces, namespace) || slices.Contains(rule.Namespaces, "*") {
+			return nil
+		}
+
+		if rule.selector == nil {
+			continue
+		}
+
+		if !labelsRead {
+			ns, err := p.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("error getting namespace %s to check policy: %w", namespace, err)
+			}
+
+			namespaceLabels = labels.Set(ns.Labels)
+			labelsRead = true
+		}
+
+		if rule.selector.Matches(namespaceLabels) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("namespace %s may not solve for %s in zone %s, %w", namespace, name, zone, ErrNotAuthorized)
+}
+
+// matchesZone reports whether the record name is in one of the rule's zones
+func (r *policyRule) matchesZone(name string) bool {
+	for _, allowed := range r.Zones {
+		if zoneHasSuffix(name, normalizeZone(allowed)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *policyRule) matchesName(name string) bool {
+	if len(r.names) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.names {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
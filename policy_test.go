@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testPolicy = `
+deniedZones:
+  - internal.example.com
+rules:
+  - namespaces: [team-a]
+    zones: [example.com]
+    names: ['^_acme-challenge\.(www|api)\.example\.com$']
+  - namespaceSelector:
+      matchLabels:
+        tenant: b
+    zones: [example.org]
+  - namespaces: [cert-manager]
+    zones: [example.com, example.org]
+  - namespaces: [team-e]
+    zones: [team-e.example.com]
+  - namespaces: [team-f]
+    zones: [example.net]
+    names: [www, '_acme-challenge\.www\.example\.net']
+`
+
+func TestParsePolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "valid", policy: testPolicy},
+		{name: "empty", policy: ``, wantErr: true},
+		{name: "unknown field", policy: `{rules: [{namespaces: [a], zones: [example.com], zone: x}]}`, wantErr: true},
+		{name: "no namespaces", policy: `{rules: [{zones: [example.com]}]}`, wantErr: true},
+		{name: "no zones", policy: `{rules: [{namespaces: [a]}]}`, wantErr: true},
+		{name: "invalid zone", policy: `{rules: [{namespaces: [a], zones: [example_com]}]}`, wantErr: true},
+		{name: "invalid namespace", policy: `{rules: [{namespaces: [A], zones: [example.com]}]}`, wantErr: true},
+		{name: "invalid name", policy: `{rules: [{namespaces: [a], zones: [example.com], names: ['(']}]}`, wantErr: true},
+		{
+			name:    "invalid selector",
+			policy:  `{rules: [{namespaceSelector: {matchLabels: {"a b": c}}, zones: [example.com]}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parsePolicy([]byte(testCase.policy), nil)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("parsePolicy() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidPolicy) {
+				t.Errorf("parsePolicy() error = %v, want %v", err, ErrInvalidPolicy)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"tenant": "b"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-c"}},
+	)
+
+	policy, err := parsePolicy([]byte(testPolicy), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		dnsName   string
+		zone      string
+		wantErr   bool
+	}{
+		{name: "allowed name", namespace: "team-a", dnsName: "www", zone: "example.com"},
+		{name: "name not allowed", namespace: "team-a", dnsName: "mail", zone: "example.com", wantErr: true},
+		{name: "zone not allowed", namespace: "team-a", dnsName: "www", zone: "example.org", wantErr: true},
+		{name: "selected namespace", namespace: "team-b", dnsName: "www", zone: "example.org"},
+		{name: "subdomain zone", namespace: "team-b", dnsName: "www", zone: "dev.example.org"},
+		{name: "unselected namespace", namespace: "team-c", dnsName: "www", zone: "example.org", wantErr: true},
+		{name: "unknown namespace", namespace: "team-d", dnsName: "www", zone: "example.org", wantErr: true},
+		{name: "cluster issuer", namespace: "cert-manager", dnsName: "www", zone: "example.com"},
+		{name: "denied zone", namespace: "cert-manager", dnsName: "www", zone: "internal.example.com", wantErr: true},
+		{
+			name: "denied subdomain in hosted zone", namespace: "cert-manager", dnsName: "www.internal",
+			zone: "example.com", wantErr: true,
+		},
+		{name: "rule subdomain in hosted zone", namespace: "team-e", dnsName: "www.team-e", zone: "example.com"},
+		{name: "whole name", namespace: "team-f", dnsName: "www", zone: "example.net"},
+		{name: "name inside a label", namespace: "team-f", dnsName: "evil-www", zone: "example.net", wantErr: true},
+		{name: "name as a prefix", namespace: "team-f", dnsName: "www.evil", zone: "example.net", wantErr: true},
+		{name: "rule parent of subdomain", namespace: "team-e", dnsName: "www", zone: "example.com", wantErr: true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			challenge := newTestChallenge(testCase.dnsName, "policy-key")
+			challenge.ResourceNamespace = testCase.namespace
+			challenge.ResolvedZone = testCase.zone + "."
+			challenge.ResolvedFQDN = "_acme-challenge." + testCase.dnsName + "." + testCase.zone + "."
+
+			err := policy.authorize(t.Context(), challenge)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("authorize() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestPresentNotAuthorized(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+
+	policy, err := parsePolicy([]byte(`{rules: [{namespaces: [cert-manager], zones: [example.org]}]}`), solver.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	solver.policy = policy
+
+	err = solver.Present(newTestChallenge("policy", "policy-key"))
+	if !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("Present() error = %v, want %v", err, ErrNotAuthorized)
+	}
+
+	if records := api.recordList(); len(records) != 0 {
+		t.Errorf("records = %v, want none", records)
+	}
+}
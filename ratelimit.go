@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+const (
+	limitNamespace   = "namespace"
+	limitZone        = "zone"
+	limitOutstanding = "outstanding"
+
+	// outstandingRecordTTL is how long a presented record counts against its
+	// namespace if CleanUp never comes
+	outstandingRecordTTL = 24 * time.Hour
+)
+
+// challengeLimiter limits the Present calls of each namespace and zone over
+// a sliding window, and the challenge records each namespace may have
+// presented and not cleaned up, so one namespace cannot use up the shared
+// NetActuate API quota. Limits are kept in memory and apply per replica.
+// A nil *challengeLimiter allows everything.
+type challengeLimiter struct {
+	metrics *solverMetrics
+	now     func() time.Time
+
+	namespaceCalls map[string][]time.Time
+	zoneCalls      map[string][]time.Time
+	// outstanding holds the presented records of each namespace, by
+	// challengeID
+	outstanding map[string]map[string]time.Time
+
+	// reserved holds the challenges counted as outstanding by allowPresent
+	// whose Present has not returned yet
+	reserved map[string]bool
+
+	window         time.Duration
+	namespaceLimit int
+	zoneLimit      int
+	maxOutstanding int
+
+	mu sync.Mutex
+}
+
+// newChallengeLimiter returns a limiter for the configured limits, or nil if
+// there are none
+func newChallengeLimiter(settings webhookSettings, metrics *solverMetrics) *challengeLimiter {
+	if settings.rateLimitNamespace == 0 && settings.rateLimitZone == 0 && settings.maxOutstandingRecords == 0 {
+		return nil
+	}
+
+	return &challengeLimiter{
+		metrics:        metrics,
+		now:            time.Now,
+		namespaceCalls: map[string][]time.Time{},
+		zoneCalls:      map[string][]time.Time{},
+		outstanding:    map[string]map[string]time.Time{},
+		reserved:       map[string]bool{},
+		window:         settings.rateLimitWindow,
+		namespaceLimit: settings.rateLimitNamespace,
+		zoneLimit:      settings.rateLimitZone,
+		maxOutstanding: settings.maxOutstandingRecords,
+	}
+}
+
+// allowPresent counts a Present call, or returns ErrRateLimited if it would
+// exceed a limit. A challenge already presented does not count against the
+// outstanding records again, others are counted as outstanding right away so
+// concurrent Present calls cannot all pass the limit. Present must report
+// its result with presented or presentFailed.
+func (l *challengeLimiter) allowPresent(challengeRequest *v1alpha1.ChallengeRequest) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	namespace := challengeRequest.ResourceNamespace
+	zone := normalizeZone(challengeRequest.ResolvedZone)
+	id := challengeID(challengeRequest)
+
+	outstanding := l.outstanding[namespace]
+	for other, presented := range outstanding {
+		if now.Sub(presented) > outstandingRecordTTL && !l.reserved[other] {
+			delete(outstanding, other)
+		}
+	}
+
+	_, presented := outstanding[id]
+	if l.maxOutstanding > 0 && !presented && len(outstanding) >= l.maxOutstanding {
+		l.metrics.rateLimited(limitOutstanding, namespace)
+
+		return fmt.Errorf("namespace %s has %d challenge records outstanding, limit %d, %w",
+			namespace, len(outstanding), l.maxOutstanding, ErrRateLimited)
+	}
+
+	namespaceCalls := l.recentCalls(l.namespaceCalls, namespace, now)
+	if l.namespaceLimit > 0 && len(namespaceCalls) >= l.namespaceLimit {
+		l.metrics.rateLimited(limitNamespace, namespace)
+
+		return fmt.Errorf("namespace %s made %d Present calls in the last %s, limit %d, %w",
+			namespace, len(namespaceCalls), l.window, l.namespaceLimit, ErrRateLimited)
+	}
+
+	zoneCalls := l.recentCalls(l.zoneCalls, zone, now)
+	if l.zoneLimit > 0 && len(zoneCalls) >= l.zoneLimit {
+		l.metrics.rateLimited(limitZone, namespace)
+
+		return fmt.Errorf("zone %s had %d Present calls in the last %s, limit %d, %w",
+			zone, len(zoneCalls), l.window, l.zoneLimit, ErrRateLimited)
+	}
+
+	l.namespaceCalls[namespace] = append(namespaceCalls, now)
+	l.zoneCalls[zone] = append(zoneCalls, now)
+
+	if !presented {
+		if outstanding == nil {
+			outstanding = map[string]time.Time{}
+			l.outstanding[namespace] = outstanding
+		}
+
+		outstanding[id] = now
+		l.reserved[id] = true
+	}
+
+	return nil
+}
+
+// recentCalls drops the calls of key that are outside the window and returns
+// the rest
+func (l *challengeLimiter) recentCalls(calls map[string][]time.Time, key string, now time.Time) []time.Time {
+	recent := calls[key]
+
+	for len(recent) > 0 && now.Sub(recent[0]) >= l.window {
+		recent = recent[1:]
+	}
+
+	if len(recent) == 0 {
+		delete(calls, key)
+	}
+
+	return recent
+}
+
+// presented counts a challenge's record as outstanding
+func (l *challengeLimiter) presented(challengeRequest *v1alpha1.ChallengeRequest) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := challengeID(challengeRequest)
+	delete(l.reserved, id)
+
+	outstanding, ok := l.outstanding[challengeRequest.ResourceNamespace]
+	if !ok {
+		outstanding = map[string]time.Time{}
+		l.outstanding[challengeRequest.ResourceNamespace] = outstanding
+	}
+
+	if _, ok = outstanding[id]; !ok {
+		outstanding[id] = l.now()
+	}
+}
+
+// presentFailed stops counting a challenge's record as outstanding if
+// allowPresent counted it for the Present that failed
+func (l *challengeLimiter) presentFailed(challengeRequest *v1alpha1.ChallengeRequest) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.reserved[challengeID(challengeRequest)] {
+		return
+	}
+
+	l.forget(challengeRequest)
+}
+
+// cleanedUp stops counting a challenge's record as outstanding
+func (l *challengeLimiter) cleanedUp(challengeRequest *v1alpha1.ChallengeRequest) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.forget(challengeRequest)
+}
+
+// forget drops a challenge from the outstanding records, l.mu must be held
+func (l *challengeLimiter) forget(challengeRequest *v1alpha1.ChallengeRequest) {
+	id := challengeID(challengeRequest)
+	delete(l.reserved, id)
+
+	outstanding := l.outstanding[challengeRequest.ResourceNamespace]
+	delete(outstanding, id)
+
+	if len(outstanding) == 0 {
+		delete(l.outstanding, challengeRequest.ResourceNamespace)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newTestLimiter(t *testing.T, settings webhookSettings, now *time.Time) *challengeLimiter {
+	t.Helper()
+
+	metrics, err := newSolverMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings.rateLimitWindow = time.Hour
+
+	limiter := newChallengeLimiter(settings, metrics)
+	limiter.now = func() time.Time { return *now }
+
+	return limiter
+}
+
+func TestChallengeLimiterRates(t *testing.T) {
+	t.Parallel()
+
+	// a challenge in otherNamespace and otherZone is not limited
+	tests := []struct {
+		name           string
+		limit          string
+		otherNamespace string
+		otherZone      string
+		settings       webhookSettings
+	}{
+		{
+			name:           "namespace",
+			limit:          limitNamespace,
+			otherNamespace: "other-namespace",
+			otherZone:      fakeZone + ".",
+			settings:       webhookSettings{rateLimitNamespace: 2},
+		},
+		{
+			name:           "zone",
+			limit:          limitZone,
+			otherNamespace: "cert-manager",
+			otherZone:      "example.org.",
+			settings:       webhookSettings{rateLimitZone: 2},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			now := time.Now()
+			limiter := newTestLimiter(t, testCase.settings, &now)
+			challenge := newTestChallenge("limited", "limited-key")
+
+			for range 2 {
+				err := limiter.allowPresent(challenge)
+				if err != nil {
+					t.Fatalf("allowPresent() under the limit error = %v", err)
+				}
+			}
+
+			err := limiter.allowPresent(challenge)
+			if !errors.Is(err, ErrRateLimited) {
+				t.Errorf("allowPresent() over the limit error = %v, want %v", err, ErrRateLimited)
+			}
+
+			other := newTestChallenge("other", "other-key")
+			other.ResourceNamespace = testCase.otherNamespace
+			other.ResolvedZone = testCase.otherZone
+
+			err = limiter.allowPresent(other)
+			if err != nil {
+				t.Errorf("allowPresent() of another %s error = %v", testCase.limit, err)
+			}
+
+			now = now.Add(time.Hour)
+
+			err = limiter.allowPresent(challenge)
+			if err != nil {
+				t.Errorf("allowPresent() after the window error = %v", err)
+			}
+
+			limited := limiter.metrics.rateLimitedCalls.WithLabelValues(testCase.limit, "cert-manager")
+			if got := testutil.ToFloat64(limited); got != 1 {
+				t.Errorf("rate limited calls = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestChallengeLimiterOutstanding(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	limiter := newTestLimiter(t, webhookSettings{maxOutstandingRecords: 1}, &now)
+	first := newTestChallenge("first", "first-key")
+	second := newTestChallenge("second", "second-key")
+
+	err := limiter.allowPresent(first)
+	if err != nil {
+		t.Fatalf("allowPresent() error = %v", err)
+	}
+
+	limiter.presented(first)
+
+	// Present is repeated for a challenge that is already outstanding
+	err = limiter.allowPresent(first)
+	if err != nil {
+		t.Errorf("allowPresent() of an outstanding challenge error = %v", err)
+	}
+
+	err = limiter.allowPresent(second)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("allowPresent() over the outstanding limit error = %v, want %v", err, ErrRateLimited)
+	}
+
+	limiter.cleanedUp(first)
+
+	err = limiter.allowPresent(second)
+	if err != nil {
+		t.Errorf("allowPresent() after CleanUp error = %v", err)
+	}
+
+	limiter.presented(second)
+	now = now.Add(outstandingRecordTTL + time.Minute)
+
+	err = limiter.allowPresent(first)
+	if err != nil {
+		t.Errorf("allowPresent() after an outstanding record expired error = %v", err)
+	}
+}
+
+func TestChallengeLimiterOutstandingReserved(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	limiter := newTestLimiter(t, webhookSettings{maxOutstandingRecords: 1}, &now)
+	first := newTestChallenge("first", "first-key")
+	second := newTestChallenge("second", "second-key")
+
+	err := limiter.allowPresent(first)
+	if err != nil {
+		t.Fatalf("allowPresent() error = %v", err)
+	}
+
+	// the first Present has not returned yet, its record already counts
+	err = limiter.allowPresent(second)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("allowPresent() while a Present is in flight error = %v, want %v", err, ErrRateLimited)
+	}
+
+	limiter.presentFailed(first)
+
+	err = limiter.allowPresent(second)
+	if err != nil {
+		t.Fatalf("allowPresent() after a failed Present error = %v", err)
+	}
+
+	limiter.presented(second)
+
+	// a repeated Present failing leaves the outstanding record counted
+	err = limiter.allowPresent(second)
+	if err != nil {
+		t.Fatalf("allowPresent() of an outstanding challenge error = %v", err)
+	}
+
+	limiter.presentFailed(second)
+
+	err = limiter.allowPresent(first)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("allowPresent() after a repeated Present failed error = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestChallengeLimiterOutstandingWithoutUID(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	limiter := newTestLimiter(t, webhookSettings{maxOutstandingRecords: 2}, &now)
+
+	// cert-manager leaves the UID of challenge requests empty, as do test
+	// challenges
+	challenges := []*v1alpha1.ChallengeRequest{
+		newTestChallenge("first", "first-key"),
+		newTestChallenge("first", "second-key"),
+		newTestChallenge("third", "third-key"),
+	}
+
+	for _, challenge := range challenges[:2] {
+		err := limiter.allowPresent(challenge)
+		if err != nil {
+			t.Fatalf("allowPresent() error = %v", err)
+		}
+
+		limiter.presented(challenge)
+	}
+
+	err := limiter.allowPresent(challenges[2])
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("allowPresent() over the outstanding limit error = %v, want %v", err, ErrRateLimited)
+	}
+
+	// cleaning up one challenge leaves the others of its namespace counted
+	limiter.cleanedUp(challenges[0])
+
+	err = limiter.allowPresent(challenges[2])
+	if err != nil {
+		t.Fatalf("allowPresent() after CleanUp error = %v", err)
+	}
+
+	limiter.presented(challenges[2])
+
+	err = limiter.allowPresent(challenges[0])
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("allowPresent() with the limit reached again error = %v, want %v", err, ErrRateLimited)
+	}
+}
+
+func TestNewChallengeLimiterUnlimited(t *testing.T) {
+	t.Parallel()
+
+	if limiter := newChallengeLimiter(webhookSettings{rateLimitWindow: time.Hour}, nil); limiter != nil {
+		t.Errorf("newChallengeLimiter() without limits = %v, want nil", limiter)
+	}
+}
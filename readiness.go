@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+)
+
+const (
+	readinessUnknown      = "unknown"
+	readinessReady        = "ready"
+	readinessNetworkError = "network_error"
+	readinessUnauthorized = "unauthorized"
+	readinessIPNotAllowed = "ip_not_allowed"
+	readinessCircuitOpen  = "circuit_open"
+	readinessError        = "error"
+
+	readinessCheckTimeout = 10 * time.Second
+)
+
+// readinessStates lists every state of the readiness check
+var readinessStates = []string{
+	readinessUnknown, readinessReady, readinessNetworkError, readinessUnauthorized, readinessIPNotAllowed,
+	readinessCircuitOpen, readinessError,
+}
+
+// apiReadiness periodically checks that the NetActuate API can be reached
+// and accepts the configured API key from this pod's address, and serves the
+// last result and the API client's circuit breaker state on /readyz, so
+// probes never call NetActuate themselves.
+type apiReadiness struct {
+	checkedAt time.Time
+	err       error
+	check     func(ctx context.Context) error
+	circuit   func() string
+	metrics   *solverMetrics
+	state     string
+	mu        sync.RWMutex
+}
+
+// readinessStatus is the body of a /readyz response
+type readinessStatus struct {
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	State     string     `json:"state"`
+	Circuit   string     `json:"circuit,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// startReadinessCheck checks the API now and then every readinessInterval
+// until stopCh is closed
+func (c *customDNSProviderSolver) startReadinessCheck(stopCh <-chan struct{}) *apiReadiness {
+	readiness := &apiReadiness{
+		check:   c.checkAPI,
+		circuit: c.api.CircuitState,
+		metrics: c.metrics,
+		state:   readinessUnknown,
+	}
+	readiness.metrics.readinessState(readinessUnknown)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancel()
+
+		ticker := time.NewTicker(c.settings.readinessInterval)
+		defer ticker.Stop()
+
+		for {
+			readiness.run(ctx)
+
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return readiness
+}
+
+// checkAPI makes the cheapest authenticated call, listing the zones, with the
+// readiness API key
+func (c *customDNSProviderSolver) checkAPI(ctx context.Context) error {
+	secret, err := c.secrets.get(ctx, c.settings.readinessSecretNamespace, c.settings.readinessSecretName)
+	if err != nil {
+		return fmt.Errorf("error getting readiness api key: %w", err)
+	}
+
+	apiKey, ok := secret.Data[c.settings.readinessSecretKey]
+	if !ok {
+		return fmt.Errorf("secret key not found, namespace: %s name: %s, key: %s, %w",
+			c.settings.readinessSecretNamespace, c.settings.readinessSecretName, c.settings.readinessSecretKey,
+			ErrAPIKeyDecode)
+	}
+
+	_, err = c.api.DNSZoneGet(ctx, string(apiKey))
+
+	return err //nolint:wrapcheck // classified by readinessState
+}
+
+// run runs the check and records its result
+func (r *apiReadiness) run(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	err := r.check(ctx)
+	state := readinessState(err)
+
+	r.mu.Lock()
+	previous := r.state
+	r.state = state
+	r.err = err
+	r.checkedAt = time.Now()
+	r.mu.Unlock()
+
+	r.metrics.readinessState(state)
+
+	if state != previous {
+		if err != nil {
+			slog.WarnContext(ctx, "NetActuate API not ready", "state", state, "err", err)
+		} else {
+			slog.InfoContext(ctx, "NetActuate API ready")
+		}
+	}
+}
+
+// readinessState returns the state of the readiness check for the result of
+// the API call
+func readinessState(err error) string {
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return readinessReady
+	case errors.Is(err, netactuate.ErrIPNotAllowed):
+		return readinessIPNotAllowed
+	case errors.Is(err, netactuate.ErrServiceUnavailable):
+		return readinessCircuitOpen
+	case errors.Is(err, netactuate.ErrUnauthorized):
+		return readinessUnauthorized
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return readinessNetworkError
+	default:
+		return readinessError
+	}
+}
+
+// ServeHTTP serves the last result, 200 if the API is ready and 503 if not
+func (r *apiReadiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.mu.RLock()
+
+	status := readinessStatus{State: r.state}
+	if !r.checkedAt.IsZero() {
+		checkedAt := r.checkedAt
+		status.CheckedAt = &checkedAt
+	}
+
+	if r.err != nil {
+		status.Error = redactMessage(r.err.Error())
+	}
+
+	r.mu.RUnlock()
+
+	if r.circuit != nil {
+		status.Circuit = r.circuit()
+	}
+
+	w.Header().Set("content-type", "application/json")
+
+	if status.State != readinessReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+)
+
+func TestReadinessState(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "ready", err: nil, want: readinessReady},
+		{name: "ip not allowed", err: fmt.Errorf("test: %w", netactuate.ErrIPNotAllowed), want: readinessIPNotAllowed},
+		{name: "circuit open", err: fmt.Errorf("test: %w", netactuate.ErrServiceUnavailable), want: readinessCircuitOpen},
+		{name: "unauthorized", err: fmt.Errorf("test: %w", netactuate.ErrUnauthorized), want: readinessUnauthorized},
+		{
+			name: "connection refused",
+			err:  &url.Error{Op: "Get", URL: "https://vapi2.netactuate.com", Err: syscall.ECONNREFUSED},
+			want: readinessNetworkError,
+		},
+		{name: "timeout", err: fmt.Errorf("test: %w", context.DeadlineExceeded), want: readinessNetworkError},
+		{name: "other", err: fmt.Errorf("test: %w", netactuate.ErrHTTPNotOK), want: readinessError},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := readinessState(testCase.err); got != testCase.want {
+				t.Errorf("readinessState() = %s, want %s", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestAPIReadiness(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		secretKey  string
+		wantState  string
+		wantStatus int
+	}{
+		{name: "ready", secretKey: "key", wantState: readinessReady, wantStatus: http.StatusOK},
+		{name: "missing key", secretKey: "missing", wantState: readinessError, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			api := newFakeNetActuate(t)
+			solver := newTestSolver(t, api)
+			solver.settings.readinessSecretNamespace = "cert-manager"
+			solver.settings.readinessSecretName = "netactuate-api-key"
+			solver.settings.readinessSecretKey = testCase.secretKey
+
+			readiness := &apiReadiness{check: solver.checkAPI, circuit: solver.api.CircuitState, state: readinessUnknown}
+
+			recorder := httptest.NewRecorder()
+			readiness.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if recorder.Code != http.StatusServiceUnavailable {
+				t.Errorf("status before the first check = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+			}
+
+			readiness.run(t.Context())
+
+			recorder = httptest.NewRecorder()
+			readiness.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var status readinessStatus
+
+			err := json.NewDecoder(recorder.Body).Decode(&status)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if recorder.Code != testCase.wantStatus || status.State != testCase.wantState || status.CheckedAt == nil ||
+				status.Circuit != netactuate.CircuitClosed {
+				t.Errorf("readiness = %d %+v, want %d %s", recorder.Code, status, testCase.wantStatus, testCase.wantState)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const secretCacheSyncTimeout = time.Minute
+
+// secretCache serves secrets from informers watching the configured
+// namespaces. Secrets in namespaces that are not watched, and secrets the
+// label selector leaves out of the watch, are read from the Kubernetes API
+// directly.
+type secretCache struct {
+	client   kubernetes.Interface
+	listers  map[string]corelisters.SecretLister
+	filtered bool
+}
+
+// newSecretCache starts informers for secrets in the given namespaces,
+// matching labelSelector, and waits for them to sync. The informers run until
+// stopCh is closed.
+func newSecretCache(
+	client kubernetes.Interface, namespaces []string, labelSelector string, stopCh <-chan struct{},
+) (*secretCache, error) {
+	secrets := &secretCache{
+		client:   client,
+		listers:  map[string]corelisters.SecretLister{},
+		filtered: labelSelector != "",
+	}
+
+	if slices.Contains(namespaces, "*") {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var hasSynced []cache.InformerSynced
+
+	for _, namespace := range namespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = labelSelector
+			}),
+		)
+
+		informer := factory.Core().V1().Secrets()
+
+		_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: secretUpdated,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error adding secret event handler: %w", err)
+		}
+
+		secrets.listers[namespace] = informer.Lister()
+		hasSynced = append(hasSynced, informer.Informer().HasSynced)
+
+		factory.Start(stopCh)
+	}
+
+	syncCtx, cancel := context.WithTimeout(context.Background(), secretCacheSyncTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-syncCtx.Done():
+		}
+	}()
+
+	if !cache.WaitForCacheSync(syncCtx.Done(), hasSynced...) {
+		return nil, fmt.Errorf("namespaces: %v, %w", namespaces, ErrSecretCacheSync)
+	}
+
+	slog.InfoContext(context.Background(), "Secret cache synced",
+		"namespaces", namespaces,
+		"labelSelector", labelSelector,
+	)
+
+	return secrets, nil
+}
+
+// get returns the named secret, from the cache if its namespace is watched
+func (s *secretCache) get(ctx context.Context, namespace string, name string) (*corev1.Secret, error) {
+	lister, ok := s.listers[namespace]
+	if !ok {
+		lister, ok = s.listers[metav1.NamespaceAll]
+	}
+
+	if !ok {
+		return s.read(ctx, namespace, name)
+	}
+
+	secret, err := lister.Secrets(namespace).Get(name)
+	if apierrors.IsNotFound(err) && s.filtered {
+		// the secret may exist without the labels the watch selects
+		return s.read(ctx, namespace, name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting cached secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// read returns the named secret from the Kubernetes API
+func (s *secretCache) read(ctx context.Context, namespace string, name string) (*corev1.Secret, error) {
+	secret, err := s.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// secretUpdated logs changes to watched secrets, the cache itself is already
+// up to date when it is called so rotated keys are used by the next challenge
+func secretUpdated(oldObj any, newObj any) {
+	oldSecret, ok := oldObj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+
+	newSecret, ok := newObj.(*corev1.Secret)
+	if !ok || oldSecret.ResourceVersion == newSecret.ResourceVersion {
+		return
+	}
+
+	slog.InfoContext(context.Background(), "Secret updated",
+		"namespace", newSecret.Namespace,
+		"name", newSecret.Name,
+	)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretCacheRotation(t *testing.T) {
+	t.Parallel()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "netactuate-api-key", Namespace: "cert-manager"},
+		Data:       map[string][]byte{"key": []byte("old")},
+	}
+
+	client := fake.NewClientset(secret)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	secrets, err := newSecretCache(client, []string{"cert-manager"}, "", stopCh)
+	if err != nil {
+		t.Fatalf("newSecretCache() error = %v", err)
+	}
+
+	got, err := secrets.get(t.Context(), "cert-manager", "netactuate-api-key")
+	if err != nil || string(got.Data["key"]) != "old" {
+		t.Fatalf("get() = %v, %v, want old", got, err)
+	}
+
+	rotated := secret.DeepCopy()
+	rotated.Data["key"] = []byte("new")
+	rotated.ResourceVersion = "2"
+
+	_, err = client.CoreV1().Secrets("cert-manager").Update(context.Background(), rotated, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		got, err = secrets.get(t.Context(), "cert-manager", "netactuate-api-key")
+		if err == nil && string(got.Data["key"]) == "new" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("get() = %v, %v, want new", got, err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = secrets.get(t.Context(), "other", "netactuate-api-key")
+	if err == nil {
+		t.Errorf("get() from unwatched namespace found a secret that does not exist")
+	}
+}
+
+func TestSecretCacheLabelSelector(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "labelled",
+				Namespace: "cert-manager",
+				Labels:    map[string]string{"netactuate": "true"},
+			},
+			Data: map[string][]byte{"key": []byte("labelled")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "unlabelled", Namespace: "cert-manager"},
+			Data:       map[string][]byte{"key": []byte("unlabelled")},
+		},
+	)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	secrets, err := newSecretCache(client, []string{"cert-manager"}, "netactuate=true", stopCh)
+	if err != nil {
+		t.Fatalf("newSecretCache() error = %v", err)
+	}
+
+	for _, name := range []string{"labelled", "unlabelled"} {
+		got, err := secrets.get(t.Context(), "cert-manager", name)
+		if err != nil || string(got.Data["key"]) != name {
+			t.Errorf("get(%s) = %v, %v, want %s", name, got, err, name)
+		}
+	}
+
+	_, err = secrets.get(t.Context(), "cert-manager", "missing")
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("get() of a missing secret error = %v, want not found", err)
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	defaultClusterResourceNamespace = "cert-manager"
+	defaultLeaseDuration            = 30 * time.Second
+	defaultLeaseRetryInterval       = time.Second
+	defaultGCInterval               = time.Hour
+	defaultGCMinAge                 = 24 * time.Hour
+	defaultGCSecretKey              = "api-key"
+	defaultRateLimitWindow          = time.Hour
+	defaultReadinessInterval        = time.Minute
+	defaultReadinessSecretKey       = "api-key"
+	defaultCircuitBreakerThreshold  = 5
+	defaultCircuitBreakerOpen       = 30 * time.Second
+)
+
+// webhookSettings holds configuration that applies to the whole webhook
+// deployment rather than to a single issuer. It is read from the
+// environment when the webhook starts.
+type webhookSettings struct {
+	// adoptUnmarkedRecords lets CleanUp delete challenge records created
+	// before owner markers existed.
+	adoptUnmarkedRecords bool
+
+	// challengeEvents enables recording Events against Challenge resources.
+	challengeEvents bool
+
+	// clusterResourceNamespace is cert-manager's cluster resource namespace,
+	// which is the ResourceNamespace of every ClusterIssuer challenge.
+	clusterResourceNamespace string
+
+	// groupName is the webhook's API group, which identifies it in owner
+	// markers.
+	groupName string
+
+	// journal enables the journal of in flight record changes, which are
+	// recovered after a crash.
+	journal bool
+
+	// ownerID identifies the cluster in owner markers, a hash of the UID of
+	// the kube-system namespace is used if it is empty.
+	ownerID string
+
+	// leaseMode coordinates replicas with a Lease per zone or a single
+	// global Lease, replicas are not coordinated if it is empty.
+	leaseMode string
+
+	// leaseNamespace is the namespace Leases are created in.
+	leaseNamespace string
+
+	// leaseIdentity identifies this replica as a Lease holder.
+	leaseIdentity string
+
+	// leaseFallback is what to do when a Lease cannot be read or written,
+	// fail the challenge or proceed without the Lease.
+	leaseFallback string
+
+	// leaseDuration is how long a Lease is held without being renewed.
+	leaseDuration time.Duration
+
+	// leaseRetryInterval is how often a Lease held by another replica is
+	// checked.
+	leaseRetryInterval time.Duration
+
+	// gcZones lists the zones scanned for stale challenge records, the
+	// garbage collector is disabled if it is empty.
+	gcZones []string
+
+	// gcInterval is how often the garbage collector runs.
+	gcInterval time.Duration
+
+	// gcMinAge is how old a challenge record must be to be collected.
+	gcMinAge time.Duration
+
+	// gcDryRun logs stale challenge records instead of deleting them.
+	gcDryRun bool
+
+	// gcSecretNamespace, gcSecretName and gcSecretKey select the API key the
+	// garbage collector uses.
+	gcSecretNamespace string
+	gcSecretName      string
+	gcSecretKey       string
+
+	// deletionAllowedNames match the names of records, other than challenge
+	// records and owner markers, that the webhook may delete. Each must
+	// match a whole name.
+	deletionAllowedNames []*regexp.Regexp
+
+	// rateLimitWindow is the window Present calls are counted over.
+	rateLimitWindow time.Duration
+
+	// rateLimitNamespace and rateLimitZone limit the Present calls per
+	// namespace and per zone in rateLimitWindow, 0 is unlimited.
+	rateLimitNamespace int
+	rateLimitZone      int
+
+	// maxOutstandingRecords limits the challenge records a namespace may have
+	// presented and not cleaned up, 0 is unlimited.
+	maxOutstandingRecords int
+
+	// readinessSecretNamespace, readinessSecretName and readinessSecretKey
+	// select the API key the readiness check uses, the check is disabled if
+	// the name is empty.
+	readinessSecretNamespace string
+	readinessSecretName      string
+	readinessSecretKey       string
+
+	// readinessInterval is how often the readiness check calls NetActuate.
+	readinessInterval time.Duration
+
+	// circuitBreakerThreshold is how many consecutive failed NetActuate API
+	// requests open the circuit breaker, 0 disables it.
+	circuitBreakerThreshold int
+
+	// circuitBreakerOpenDuration is how long the open circuit breaker fails
+	// requests before letting a probe through.
+	circuitBreakerOpenDuration time.Duration
+
+	// apiTransport configures the HTTP transport used for NetActuate API
+	// calls.
+	apiTransport netactuate.TransportConfig
+
+	// policyFile is the path of the authorization policy, every challenge is
+	// authorized if it is empty.
+	policyFile string
+
+	// metricsBindAddress is the address metrics are served on, metrics are
+	// not served if it is empty.
+	metricsBindAddress string
+
+	// secretCacheLabelSelector limits the secrets held by the secret cache.
+	secretCacheLabelSelector string
+
+	// secretNamespaces lists the namespaces, other than the challenge's
+	// ResourceNamespace, that ClusterIssuer configs may read secrets from.
+	secretNamespaces []string
+
+	// secretCacheNamespaces lists the namespaces whose secrets are watched
+	// and served from the secret cache, "*" watches all namespaces.
+	secretCacheNamespaces []string
+}
+
+// loadSettings reads the webhook settings from the environment
+func loadSettings() (webhookSettings, error) {
+	settings := webhookSettings{
+		clusterResourceNamespace: os.Getenv("CLUSTER_RESOURCE_NAMESPACE"),
+		groupName:                GroupName,
+		ownerID:                  os.Getenv("OWNER_ID"),
+		policyFile:               os.Getenv("POLICY_FILE"),
+		metricsBindAddress:       os.Getenv("METRICS_BIND_ADDRESS"),
+		secretCacheLabelSelector: os.Getenv("SECRET_CACHE_LABEL_SELECTOR"),
+		secretNamespaces:         envList("SECRET_NAMESPACES"),
+		secretCacheNamespaces:    envList("SECRET_CACHE_NAMESPACES"),
+	}
+
+	var err error
+
+	settings.challengeEvents, err = envBool("CHALLENGE_EVENTS", false)
+	if err != nil {
+		return settings, err
+	}
+
+	settings.journal, err = envBool("JOURNAL", false)
+	if err != nil {
+		return settings, err
+	}
+
+	settings.adoptUnmarkedRecords, err = envBool("ADOPT_UNMARKED_RECORDS", true)
+	if err != nil {
+		return settings, err
+	}
+
+	if settings.clusterResourceNamespace == "" {
+		settings.clusterResourceNamespace = defaultClusterResourceNamespace
+	}
+
+	err = loadRateLimitSettings(&settings)
+	if err != nil {
+		return settings, err
+	}
+
+	err = loadLeaseSettings(&settings)
+	if err != nil {
+		return settings, err
+	}
+
+	err = loadGCSettings(&settings)
+	if err != nil {
+		return settings, err
+	}
+
+	for _, pattern := range envList("DELETION_ALLOWED_NAMES") {
+		var allowed *regexp.Regexp
+
+		allowed, err = compileFullMatch(pattern)
+		if err != nil {
+			return settings, fmt.Errorf("DELETION_ALLOWED_NAMES: %w: %w", ErrInvalidSetting, err)
+		}
+
+		settings.deletionAllowedNames = append(settings.deletionAllowedNames, allowed)
+	}
+
+	err = loadReadinessSettings(&settings)
+	if err != nil {
+		return settings, err
+	}
+
+	err = loadCircuitBreakerSettings(&settings)
+	if err != nil {
+		return settings, err
+	}
+
+	err = loadTransportSettings(&settings)
+	if err != nil {
+		return settings, err
+	}
+
+	_, err = labels.Parse(settings.secretCacheLabelSelector)
+	if err != nil {
+		return settings, fmt.Errorf("SECRET_CACHE_LABEL_SELECTOR: %w: %w", ErrInvalidSetting, err)
+	}
+
+	return settings, nil
+}
+
+// loadRateLimitSettings reads the Present rate limits and the cap on
+// outstanding challenge records
+func loadRateLimitSettings(settings *webhookSettings) error {
+	var err error
+
+	settings.rateLimitWindow, err = envDuration("RATE_LIMIT_WINDOW", defaultRateLimitWindow)
+	if err != nil {
+		return err
+	}
+
+	if settings.rateLimitWindow <= 0 {
+		return fmt.Errorf("RATE_LIMIT_WINDOW: must be positive, %w", ErrInvalidSetting)
+	}
+
+	settings.rateLimitNamespace, err = envInt("RATE_LIMIT_NAMESPACE", 0)
+	if err != nil {
+		return err
+	}
+
+	settings.rateLimitZone, err = envInt("RATE_LIMIT_ZONE", 0)
+	if err != nil {
+		return err
+	}
+
+	settings.maxOutstandingRecords, err = envInt("MAX_OUTSTANDING_RECORDS", 0)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// loadLeaseSettings reads the Lease coordination settings. Leases are kept in
+// the webhook's own namespace by default, and held in the name of its pod.
+func loadLeaseSettings(settings *webhookSettings) error {
+	var err error
+
+	settings.leaseMode = os.Getenv("LEASE_MODE")
+	if !slices.Contains([]string{"", leaseModeZone, leaseModeGlobal}, settings.leaseMode) {
+		return fmt.Errorf("LEASE_MODE: %s, %w", settings.leaseMode, ErrInvalidSetting)
+	}
+
+	settings.leaseFallback = cmp.Or(os.Getenv("LEASE_FALLBACK"), leaseFallbackFail)
+	if settings.leaseFallback != leaseFallbackFail && settings.leaseFallback != leaseFallbackProceed {
+		return fmt.Errorf("LEASE_FALLBACK: %s, %w", settings.leaseFallback, ErrInvalidSetting)
+	}
+
+	settings.leaseDuration, err = envDuration("LEASE_DURATION", defaultLeaseDuration)
+	if err != nil {
+		return err
+	}
+
+	if settings.leaseDuration < time.Second {
+		return fmt.Errorf("LEASE_DURATION: must be at least 1s, %w", ErrInvalidSetting)
+	}
+
+	settings.leaseRetryInterval, err = envDuration("LEASE_RETRY_INTERVAL", defaultLeaseRetryInterval)
+	if err != nil {
+		return err
+	}
+
+	if settings.leaseRetryInterval <= 0 {
+		return fmt.Errorf("LEASE_RETRY_INTERVAL: must be positive, %w", ErrInvalidSetting)
+	}
+
+	settings.leaseNamespace = cmp.Or(
+		os.Getenv("LEASE_NAMESPACE"), os.Getenv("POD_NAMESPACE"), settings.clusterResourceNamespace,
+	)
+
+	settings.leaseIdentity = os.Getenv("POD_NAME")
+	if settings.leaseIdentity == "" {
+		settings.leaseIdentity, err = os.Hostname()
+		if err != nil {
+			return fmt.Errorf("error getting lease identity: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// loadGCSettings reads the garbage collector settings. The API key secret is
+// read from the webhook's own namespace by default.
+func loadGCSettings(settings *webhookSettings) error {
+	var err error
+
+	settings.gcZones = envList("GC_ZONES")
+	if len(settings.gcZones) == 0 {
+		return nil
+	}
+
+	settings.gcInterval, err = envDuration("GC_INTERVAL", defaultGCInterval)
+	if err != nil {
+		return err
+	}
+
+	if settings.gcInterval <= 0 {
+		return fmt.Errorf("GC_INTERVAL: must be positive, %w", ErrInvalidSetting)
+	}
+
+	settings.gcMinAge, err = envDuration("GC_MIN_AGE", defaultGCMinAge)
+	if err != nil {
+		return err
+	}
+
+	settings.gcDryRun, err = envBool("GC_DRY_RUN", false)
+	if err != nil {
+		return err
+	}
+
+	settings.gcSecretNamespace = cmp.Or(os.Getenv("GC_SECRET_NAMESPACE"), settings.leaseNamespace)
+	settings.gcSecretName = os.Getenv("GC_SECRET_NAME")
+	settings.gcSecretKey = cmp.Or(os.Getenv("GC_SECRET_KEY"), defaultGCSecretKey)
+
+	if settings.gcSecretName == "" {
+		return fmt.Errorf("GC_SECRET_NAME: required when GC_ZONES is set, %w", ErrInvalidSetting)
+	}
+
+	return nil
+}
+
+// loadReadinessSettings reads the readiness check settings. The API key
+// secret is read from the webhook's own namespace by default, and the result
+// is served with the metrics.
+func loadReadinessSettings(settings *webhookSettings) error {
+	var err error
+
+	settings.readinessSecretName = os.Getenv("READINESS_SECRET_NAME")
+	if settings.readinessSecretName == "" {
+		return nil
+	}
+
+	settings.readinessSecretNamespace = cmp.Or(os.Getenv("READINESS_SECRET_NAMESPACE"), settings.leaseNamespace)
+	settings.readinessSecretKey = cmp.Or(os.Getenv("READINESS_SECRET_KEY"), defaultReadinessSecretKey)
+
+	settings.readinessInterval, err = envDuration("READINESS_INTERVAL", defaultReadinessInterval)
+	if err != nil {
+		return err
+	}
+
+	if settings.readinessInterval <= 0 {
+		return fmt.Errorf("READINESS_INTERVAL: must be positive, %w", ErrInvalidSetting)
+	}
+
+	if settings.metricsBindAddress == "" {
+		return fmt.Errorf("READINESS_SECRET_NAME: requires METRICS_BIND_ADDRESS, %w", ErrInvalidSetting)
+	}
+
+	return nil
+}
+
+// loadCircuitBreakerSettings reads the NetActuate API circuit breaker
+// settings
+func loadCircuitBreakerSettings(settings *webhookSettings) error {
+	var err error
+
+	settings.circuitBreakerThreshold, err = envInt("CIRCUIT_BREAKER_THRESHOLD", defaultCircuitBreakerThreshold)
+	if err != nil {
+		return err
+	}
+
+	settings.circuitBreakerOpenDuration, err = envDuration("CIRCUIT_BREAKER_OPEN_DURATION", defaultCircuitBreakerOpen)
+	if err != nil {
+		return err
+	}
+
+	if settings.circuitBreakerOpenDuration <= 0 {
+		return fmt.Errorf("CIRCUIT_BREAKER_OPEN_DURATION: must be positive, %w", ErrInvalidSetting)
+	}
+
+	return nil
+}
+
+// loadTransportSettings reads the proxy, CA bundle, TLS, timeout and
+// connection pool settings of the NetActuate API transport. Settings left
+// unset use the netactuate package's defaults.
+func loadTransportSettings(settings *webhookSettings) error {
+	var err error
+
+	transport := &settings.apiTransport
+	transport.CABundleFile = os.Getenv("API_CA_BUNDLE")
+
+	// the proxy URL holds credentials, so it is left out of errors
+	if proxy := os.Getenv("API_PROXY_URL"); proxy != "" {
+		transport.ProxyURL, err = url.Parse(proxy)
+		if err != nil || (transport.ProxyURL.Scheme != "http" && transport.ProxyURL.Scheme != "https") ||
+			transport.ProxyURL.Host == "" {
+			return fmt.Errorf("API_PROXY_URL: must be an http or https url, %w", ErrInvalidSetting)
+		}
+	}
+
+	switch version := os.Getenv("API_TLS_MIN_VERSION"); version {
+	case "", "1.2":
+		transport.TLSMinVersion = tls.VersionTLS12
+	case "1.3":
+		transport.TLSMinVersion = tls.VersionTLS13
+	default:
+		return fmt.Errorf("API_TLS_MIN_VERSION: %q is not 1.2 or 1.3, %w", version, ErrInvalidSetting)
+	}
+
+	for name, timeout := range map[string]*time.Duration{
+		"API_DIAL_TIMEOUT":            &transport.DialTimeout,
+		"API_TLS_HANDSHAKE_TIMEOUT":   &transport.TLSHandshakeTimeout,
+		"API_RESPONSE_HEADER_TIMEOUT": &transport.ResponseHeaderTimeout,
+		"API_TIMEOUT":                 &transport.Timeout,
+		"API_IDLE_CONN_TIMEOUT":       &transport.IdleConnTimeout,
+	} {
+		*timeout, err = envDuration(name, 0)
+		if err != nil {
+			return err
+		}
+
+		if *timeout < 0 {
+			return fmt.Errorf("%s: must not be negative, %w", name, ErrInvalidSetting)
+		}
+	}
+
+	transport.MaxIdleConns, err = envInt("API_MAX_IDLE_CONNS", 0)
+	if err != nil {
+		return err
+	}
+
+	transport.MaxIdleConnsPerHost, err = envInt("API_MAX_IDLE_CONNS_PER_HOST", 0)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// envList returns the comma separated values of an environment variable,
+// ignoring empty entries
+func envList(name string) []string {
+	var values []string
+
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// envDuration returns the duration value of an environment variable, or
+// defaultValue if it is unset
+func envDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("%s: %w: %w", name, ErrInvalidSetting, err)
+	}
+
+	return parsed, nil
+}
+
+// envBool returns the boolean value of an environment variable, or
+// defaultValue if it is unset
+func envBool(name string, defaultValue bool) (bool, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("%s: %w: %w", name, ErrInvalidSetting, err)
+	}
+
+	return parsed, nil
+}
+
+// envInt returns the non-negative integer value of an environment variable,
+// or defaultValue if it is unset
+func envInt(name string, defaultValue int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("%s: %w: %w", name, ErrInvalidSetting, err)
+	}
+
+	if parsed < 0 {
+		return defaultValue, fmt.Errorf("%s: must not be negative, %w", name, ErrInvalidSetting)
+	}
+
+	return parsed, nil
+}
+
+// compileFullMatch compiles a regular expression that must match a whole
+// string, not just part of it
+func compileFullMatch(pattern string) (*regexp.Regexp, error) {
+	compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	return compiled, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName            = "github.com/swills/cert-manager-webhook-netactuate"
+	tracingServiceName    = "cert-manager-webhook-netactuate"
+	tracingShutdownWindow = 5 * time.Second
+)
+
+// setupTracing configures OpenTelemetry tracing from the standard OTEL_*
+// environment variables. Spans are exported over OTLP/gRPC when an OTLP
+// endpoint is set, otherwise tracing is disabled. The exporter is flushed and
+// shut down when stopCh is closed.
+func setupTracing(stopCh <-chan struct{}) (trace.TracerProvider, error) {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" ||
+		(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "") {
+		return noop.NewTracerProvider(), nil
+	}
+
+	exporter, err := otlptracegrpc.New(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error creating otlp exporter: %w", err)
+	}
+
+	// resource.Default reads OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES,
+	// which take precedence over the service name set here
+	traceResource, err := resource.Merge(
+		resource.NewSchemaless(attribute.String("service.name", tracingServiceName)),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(traceResource),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	go func() {
+		<-stopCh
+
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownWindow)
+		defer cancel()
+
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error shutting down tracing", "err", err)
+		}
+	}()
+
+	slog.InfoContext(context.Background(), "Tracing enabled")
+
+	return provider, nil
+}
+
+// tracer returns the solver's tracer, which does nothing unless tracing is
+// configured
+func (c *customDNSProviderSolver) tracer() trace.Tracer {
+	if c.tracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+
+	return c.tracerProvider.Tracer(tracerName)
+}
+
+// startChallengeSpan starts the span for a Present or CleanUp call
+func (c *customDNSProviderSolver) startChallengeSpan(
+	action string, challengeRequest *v1alpha1.ChallengeRequest,
+) (context.Context, trace.Span) {
+	return c.tracer().Start(context.Background(), action, trace.WithAttributes(
+		attribute.String("challenge.uid", string(challengeRequest.UID)),
+		attribute.String("challenge.namespace", challengeRequest.ResourceNamespace),
+		attribute.String("dns.name", challengeRequest.DNSName),
+		attribute.String("dns.zone", challengeRequest.ResolvedZone),
+		attribute.String("dns.fqdn", challengeRequest.ResolvedFQDN),
+	))
+}
+
+// endSpan records err, if any, on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
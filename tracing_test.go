@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/swills/cert-manager-webhook-netactuate/netactuate"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestPresentTracing(t *testing.T) { //nolint:paralleltest // sets the global propagator
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	api := newFakeNetActuate(t)
+	solver := newTestSolver(t, api)
+	solver.tracerProvider = provider
+	solver.api = netactuate.NewClient(netactuate.WithBaseURL(api.URL), netactuate.WithTracerProvider(provider))
+
+	err := solver.Present(newTestChallenge("www", "token"))
+	if err != nil {
+		t.Fatalf("Present() error = %v", err)
+	}
+
+	spans := exporter.GetSpans()
+
+	var root tracetest.SpanStub
+
+	names := map[string]tracetest.SpanStub{}
+
+	for _, span := range spans {
+		names[span.Name] = span
+		if span.Name == "Present" {
+			root = span
+		}
+	}
+
+	for _, name := range []string{"Present", "loadAPIKey", "netactuate dns_zones", "netactuate dns_record_post"} {
+		span, ok := names[name]
+		if !ok {
+			t.Errorf("span %q not recorded, got %v", name, spans)
+
+			continue
+		}
+
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("span %q is not in the Present trace", name)
+		}
+	}
+
+	for _, header := range api.headers {
+		if header.Get("traceparent") == "" {
+			t.Errorf("request without traceparent header")
+		}
+	}
+}